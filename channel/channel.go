@@ -1,19 +1,30 @@
 package channel
 
 import (
-	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/history"
 	"github.com/jukeks/channeld/protocol"
+	"github.com/jukeks/channeld/store"
 
 	"fmt"
 	"log"
+	"strings"
 )
 
 type Channel struct {
 	Name     string
 	Incoming chan protocol.ChannelAction
+	quit     chan bool
+	done     chan bool
 
 	mode  string
 	users []*ChannelUser
+
+	store   store.ChannelStore
+	history history.Store
+
+	// empty is where the channel reports itself once its last member has
+	// left, for the server to stop it.
+	empty chan<- *Channel
 }
 
 type ChannelUser struct {
@@ -22,9 +33,13 @@ type ChannelUser struct {
 	conn     *protocol.IrcConnection
 }
 
-func NewChannel(name string) *Channel {
+func NewChannel(name string, store store.ChannelStore, history history.Store,
+	empty chan<- *Channel) *Channel {
 	c := new(Channel)
 	c.Name = name
+	c.store = store
+	c.history = history
+	c.empty = empty
 	c.users = []*ChannelUser{}
 
 	c.Incoming = make(chan protocol.ChannelAction, 1000)
@@ -32,24 +47,93 @@ func NewChannel(name string) *Channel {
 	return c
 }
 
-func (channel *Channel) Serve() {
+// Start runs the channel's goroutine, which handles the actions sent to
+// Incoming until the channel is stopped.
+func (channel *Channel) Start() {
+	channel.quit = make(chan bool)
+	channel.done = make(chan bool)
+
+	go channel.serve()
+}
+
+func (channel *Channel) serve() {
+	defer close(channel.done)
+
 	for {
 		select {
 		case action := <-channel.Incoming:
 			channel.handleMessage(action)
+			channel.checkEmpty()
+		case <-channel.quit:
+			channel.drain()
+			return
 		}
+	}
+}
+
+// Stop ends the channel's goroutine and waits for it to finish the
+// actions already queued.
+func (channel *Channel) Stop() {
+	close(channel.quit)
+	<-channel.done
+}
+
+// Empty tells whether the channel has no members. The channel must be
+// stopped when it is called.
+func (channel *Channel) Empty() bool {
+	return len(channel.users) == 0
+}
+
+// checkEmpty reports a channel that has nothing left to keep it alive.
+// The server decides whether to stop it, as it may have sent the channel
+// more actions in the meantime. The report is sent from its own goroutine
+// so that it is never lost, yet cannot deadlock with a server busy sending
+// to this channel.
+func (channel *Channel) checkEmpty() {
+	if !channel.Empty() || channel.Permanent() {
+		return
+	}
 
-		if len(channel.users) == 0 {
+	go func() {
+		channel.empty <- channel
+	}()
+}
+
+func (channel *Channel) drain() {
+	for {
+		select {
+		case action := <-channel.Incoming:
+			channel.handleMessage(action)
+		default:
 			return
 		}
 	}
 }
 
 func (channel *Channel) handleMessage(action protocol.ChannelAction) {
+	defer action.Response.Close()
+
+	// a NOTICE from outside is dropped quietly, it must never be replied to
+	switch action.Message.GetType() {
+	case protocol.PRIVATE, protocol.NOTICE, protocol.TAGMSG:
+		if channel.getUserByNick(action.OriginNick) == nil {
+			if action.Message.GetType() != protocol.NOTICE {
+				action.Response.Error(action.OriginNick,
+					protocol.ERR_CANNOTSENDTOCHAN, channel.Name)
+			}
+			return
+		}
+	}
+
 	switch action.Message.GetType() {
 	case protocol.PRIVATE:
 		msg := action.Message.(protocol.PrivateMessage)
 		channel.handlePrivateMessage(action, msg)
+	case protocol.NOTICE:
+		msg := action.Message.(protocol.NoticeMessage)
+		channel.handleNotice(action, msg)
+	case protocol.TAGMSG:
+		channel.relay(action, action.Message)
 	case protocol.JOIN:
 		msg := action.Message.(protocol.JoinMessage)
 		channel.handleJoin(action, msg)
@@ -59,6 +143,15 @@ func (channel *Channel) handleMessage(action protocol.ChannelAction) {
 	case protocol.QUIT:
 		msg := action.Message.(protocol.QuitMessage)
 		channel.handleQuit(action, msg)
+	case protocol.MODE:
+		msg := action.Message.(protocol.ModeMessage)
+		channel.handleMode(action, msg)
+	case protocol.NICK:
+		msg := action.Message.(protocol.NickMessage)
+		channel.handleNickChange(action, msg)
+	case protocol.CHGHOST:
+		msg := action.Message.(protocol.HostChangeMessage)
+		channel.handleHostChange(action, msg)
 	default:
 		log.Printf("Channel message not implemented: %v", action)
 	}
@@ -108,42 +201,75 @@ func (channel *Channel) handleJoin(action protocol.ChannelAction,
 		action.OriginConn}
 	channel.addUser(&newUser)
 
-	serialized := protocol.GetSerializedMessageFrom(action.OriginHostMask,
-		message)
+	outgoing := protocol.NewOutgoing(action.OriginHostMask, message,
+		action.Metadata)
 
-	for _, user := range channel.users {
-		user.conn.Send(serialized)
-	}
-
-	channel.sendUsers(newUser.hostmask, newUser.conn)
+	channel.broadcast(action, outgoing)
+	channel.record(action, message)
+	channel.sendUsers(newUser.nick, action.Response)
 }
 
 func (channel *Channel) handlePart(action protocol.ChannelAction,
 	message protocol.PartMessage) {
 	leavingUser := channel.getUserByNick(action.OriginNick)
-	channel.removeUser(leavingUser)
+	if leavingUser == nil {
+		action.Response.Error(action.OriginNick, protocol.ERR_NOTONCHANNEL,
+			channel.Name)
+		return
+	}
 
-	serialized := protocol.GetSerializedMessageFrom(action.OriginHostMask,
-		message)
+	channel.removeUser(leavingUser)
 
-	for _, user := range channel.users {
-		user.conn.Send(serialized)
-	}
+	outgoing := protocol.NewOutgoing(action.OriginHostMask, message,
+		action.Metadata)
 
-	leavingUser.conn.Send(serialized)
+	channel.broadcast(action, outgoing)
+	action.Response.SendOutgoing(outgoing)
+	channel.record(action, message)
 }
 
 func (channel *Channel) handlePrivateMessage(action protocol.ChannelAction,
 	message protocol.PrivateMessage) {
-	serialized := protocol.GetSerializedMessageFrom(action.OriginHostMask,
-		message)
+	channel.relay(action, message)
+	channel.record(action, message)
+}
+
+func (channel *Channel) handleNotice(action protocol.ChannelAction,
+	message protocol.NoticeMessage) {
+	channel.relay(action, message)
+	channel.record(action, message)
+}
+
+// relay sends a message to everyone on the channel but the sender, who
+// only gets it with echo-message.
+func (channel *Channel) relay(action protocol.ChannelAction,
+	message protocol.IrcMessage) {
+	outgoing := protocol.NewOutgoing(action.OriginHostMask, message,
+		action.Metadata)
 
 	for _, user := range channel.users {
 		if user.nick == action.OriginNick {
+			if user.conn.Capabilities().Has(protocol.CapEchoMessage) {
+				action.Response.SendOutgoing(outgoing)
+			}
+			continue
+		}
+
+		user.conn.SendOutgoing(outgoing)
+	}
+}
+
+// broadcast sends a message to everyone on the channel, to the origin as
+// part of the response to its command.
+func (channel *Channel) broadcast(action protocol.ChannelAction,
+	outgoing *protocol.Outgoing) {
+	for _, user := range channel.users {
+		if user.conn == action.OriginConn {
+			action.Response.SendOutgoing(outgoing)
 			continue
 		}
 
-		user.conn.Send(serialized)
+		user.conn.SendOutgoing(outgoing)
 	}
 }
 
@@ -156,31 +282,74 @@ func (channel *Channel) handleQuit(action protocol.ChannelAction,
 
 	channel.removeUser(quitingUser)
 
-	serialized := protocol.GetSerializedMessageFrom(action.OriginHostMask,
-		message)
+	outgoing := protocol.NewOutgoing(action.OriginHostMask, message,
+		action.Metadata)
 
-	for _, user := range channel.users {
-		user.conn.Send(serialized)
+	channel.broadcast(action, outgoing)
+	channel.record(action, message)
+}
+
+// handleNickChange renames a member, whose peers have been told by the
+// server already.
+func (channel *Channel) handleNickChange(action protocol.ChannelAction,
+	message protocol.NickMessage) {
+	user := channel.getUserByNick(action.OriginNick)
+	if user == nil {
+		return
+	}
+
+	user.nick = message.Nick
+	user.hostmask = message.Nick +
+		user.hostmask[strings.IndexByte(user.hostmask, '!'):]
+}
+
+// handleHostChange updates the hostmask of a member, whose peers have been
+// told by the server already.
+func (channel *Channel) handleHostChange(action protocol.ChannelAction,
+	message protocol.HostChangeMessage) {
+	user := channel.getUserByNick(action.OriginNick)
+	if user == nil {
+		return
+	}
+
+	user.hostmask = fmt.Sprintf("%s!%s@%s", user.nick, message.Username,
+		message.Hostname)
+}
+
+// record adds a message sent to the channel to its history, under the id
+// and time it was given on arrival.
+func (channel *Channel) record(action protocol.ChannelAction,
+	message protocol.IrcMessage) {
+	command := message.Serialize()
+	if i := strings.IndexByte(command, ' '); i >= 0 {
+		command = command[:i]
 	}
+
+	channel.history.Add(channel.Name, history.Entry{action.Metadata.ID,
+		action.Metadata.Time, action.OriginHostMask, command,
+		message.Serialize()})
 }
 
-func (channel *Channel) sendUsers(target string, conn *protocol.IrcConnection) {
-	serverId := config.Config.ServerID
-	template := fmt.Sprintf(":%s 353 %s @ %s :",
-		serverId, target, channel.Name)
+func (channel *Channel) sendUsers(target string, response *protocol.Response) {
+	prefix := len(protocol.NewNumeric(protocol.RPL_NAMREPLY, target, "@",
+		channel.Name, "").Serialize())
 
 	buff := ""
 	for _, u := range channel.getUserNames() {
-		if len(template)+len(buff)+len(u)+1 > 510 {
-			conn.Send(fmt.Sprintf("%s%s", template, buff))
+		if buff != "" && prefix+len(buff)+len(u)+1 > 510 {
+			response.SendMessage(protocol.NewNumeric(protocol.RPL_NAMREPLY,
+				target, "@", channel.Name, buff))
 			buff = ""
 		}
 
-		buff = fmt.Sprintf("%s %s", u, buff)
+		if buff != "" {
+			buff += " "
+		}
+		buff += u
 	}
 
-	conn.Send(fmt.Sprintf("%s%s", template, buff))
-
-	conn.Send(fmt.Sprintf(":%s 366 %s :End of /NAMES list",
-		serverId, target))
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_NAMREPLY, target,
+		"@", channel.Name, buff))
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_ENDOFNAMES, target,
+		channel.Name))
 }
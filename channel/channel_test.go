@@ -0,0 +1,157 @@
+package channel
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/history"
+	"github.com/jukeks/channeld/protocol"
+	"github.com/jukeks/channeld/store"
+	"github.com/stretchr/testify/assert"
+
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMember is a connection served over a pipe, along with the client's
+// end of it.
+type testMember struct {
+	nick   string
+	conn   *protocol.IrcConnection
+	socket net.Conn
+	reader *bufio.Reader
+}
+
+func newTestMember(nick string) *testMember {
+	config.Config.ServerID = "irc.example"
+	socket, client := net.Pipe()
+	conn := protocol.NewIrcConnection(socket,
+		config.Config.GetClass(config.DefaultClass),
+		make(chan protocol.ClientAction, 10))
+	go conn.Resume()
+
+	return &testMember{nick, conn, client, bufio.NewReader(client)}
+}
+
+func (m *testMember) action(message protocol.IrcMessage) protocol.ChannelAction {
+	return protocol.ChannelAction{m.nick + "!~" + m.nick + "@example.org",
+		m.nick, m.conn, message, protocol.NewMetadata(nil),
+		m.conn.NewResponse(protocol.Metadata{})}
+}
+
+// read returns the lines written to the member until it has been quiet for
+// a moment.
+func (m *testMember) read() []string {
+	lines := []string{}
+	for {
+		m.socket.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		line, err := m.reader.ReadString('\n')
+		if err != nil {
+			return lines
+		}
+
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+	}
+}
+
+func (m *testMember) close() {
+	m.socket.Close()
+	m.conn.Close()
+}
+
+func newTestChannel(empty chan *Channel) *Channel {
+	s, _ := store.OpenChannelStore("")
+	return NewChannel("#x", s, history.NewMemoryStore(10, time.Hour), empty)
+}
+
+func TestChannelLifecycle(t *testing.T) {
+	empty := make(chan *Channel, 10)
+	c := newTestChannel(empty)
+	alice := newTestMember("alice")
+	defer alice.close()
+
+	// actions queued before a stop are handled before it returns
+	c.Start()
+	c.Incoming <- alice.action(protocol.JoinMessage{"#x", "", ""})
+	c.Stop()
+	assert.False(t, c.Empty(), "Queued join not handled")
+	assert.Equal(t, len(empty), 0, "Channel with a member reported empty")
+
+	c.Start()
+	c.Incoming <- alice.action(protocol.PartMessage{"#x"})
+	select {
+	case reported := <-empty:
+		assert.Equal(t, reported, c, "Wrong channel reported")
+	case <-time.After(time.Second):
+		t.Error("Empty channel not reported")
+	}
+	c.Stop()
+	assert.True(t, c.Empty(), "Part not handled")
+
+	// the report is not lost even when the server is not listening yet
+	empty = make(chan *Channel)
+	c = newTestChannel(empty)
+	c.Start()
+	c.Incoming <- alice.action(protocol.JoinMessage{"#x", "", ""})
+	c.Incoming <- alice.action(protocol.PartMessage{"#x"})
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-empty:
+	case <-time.After(time.Second):
+		t.Error("Empty channel report lost")
+	}
+	c.Stop()
+}
+
+func TestPermanentChannel(t *testing.T) {
+	empty := make(chan *Channel, 10)
+	c := newTestChannel(empty)
+	alice := newTestMember("alice")
+	defer alice.close()
+
+	c.Start()
+	c.Incoming <- alice.action(protocol.JoinMessage{"#x", "", ""})
+	c.Incoming <- alice.action(protocol.ModeMessage{"#x", "+P"})
+	c.Incoming <- alice.action(protocol.PartMessage{"#x"})
+	c.Stop()
+
+	assert.True(t, c.Permanent(), "Mode not set")
+	assert.Equal(t, len(empty), 0, "Permanent channel reported empty")
+	assert.Equal(t, c.State(), ChannelState{"#x", "P", []MemberState{}},
+		"Permanent channel not kept")
+}
+
+func TestChannelMode(t *testing.T) {
+	c := newTestChannel(make(chan *Channel, 10))
+	alice := newTestMember("alice")
+	defer alice.close()
+	oper := newTestMember("oper")
+	defer oper.close()
+
+	c.Start()
+	defer c.Stop()
+
+	c.Incoming <- alice.action(protocol.JoinMessage{"#x", "", ""})
+	alice.read()
+
+	c.Incoming <- alice.action(protocol.ModeMessage{"#x", ""})
+	assert.Equal(t, alice.read(), []string{":irc.example 324 alice #x +"},
+		"Modes not shown")
+
+	c.Incoming <- alice.action(protocol.ModeMessage{"#x", "+Pz"})
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 472 alice z :is unknown mode char to me",
+		":alice!~alice@example.org MODE #x +P",
+	}, "Mode change not applied")
+
+	c.Incoming <- alice.action(protocol.ModeMessage{"#x", "+P"})
+	assert.Equal(t, len(alice.read()), 0, "Mode set twice")
+
+	// operators may change the modes from outside the channel
+	c.Incoming <- oper.action(protocol.ModeMessage{"#x", "-P"})
+	assert.Equal(t, oper.read(), []string{":oper!~oper@example.org MODE #x -P"},
+		"Change from outside not shown to its origin")
+	assert.Equal(t, alice.read(), []string{":oper!~oper@example.org MODE #x -P"},
+		"Change from outside not shown to members")
+}
@@ -0,0 +1,87 @@
+package channel
+
+import (
+	"github.com/jukeks/channeld/protocol"
+
+	"strings"
+)
+
+// supportedModes are the channel modes known to the server:
+//
+//	P  permanent, the channel is kept when empty and across restarts
+const supportedModes = "P"
+
+func (channel *Channel) hasMode(mode rune) bool {
+	return strings.ContainsRune(channel.mode, mode)
+}
+
+func (channel *Channel) setMode(mode rune, set bool) bool {
+	if channel.hasMode(mode) == set {
+		return false
+	}
+
+	if set {
+		channel.mode += string(mode)
+	} else {
+		channel.mode = strings.Replace(channel.mode, string(mode), "", 1)
+	}
+
+	return true
+}
+
+// handleMode shows or changes the channel's modes. Who may change which
+// mode has been checked by the server already.
+func (channel *Channel) handleMode(action protocol.ChannelAction,
+	message protocol.ModeMessage) {
+	if message.Modes == "" {
+		action.Response.SendMessage(protocol.NewNumeric(
+			protocol.RPL_CHANNELMODEIS, action.OriginNick, channel.Name,
+			"+"+channel.mode))
+		return
+	}
+
+	applied := ""
+	sign := ' '
+	set := true
+	for _, mode := range message.Modes {
+		switch {
+		case mode == '+' || mode == '-':
+			set = mode == '+'
+		case strings.ContainsRune(supportedModes, mode):
+			if !channel.setMode(mode, set) {
+				continue
+			}
+
+			want := '-'
+			if set {
+				want = '+'
+			}
+
+			if want != sign {
+				sign = want
+				applied += string(sign)
+			}
+			applied += string(mode)
+		default:
+			action.Response.Error(action.OriginNick,
+				protocol.ERR_UNKNOWNMODE, string(mode))
+		}
+	}
+
+	if applied == "" {
+		return
+	}
+
+	channel.persist()
+
+	changed := protocol.ModeMessage{channel.Name, applied}
+	outgoing := protocol.NewOutgoing(action.OriginHostMask, changed,
+		action.Metadata)
+	channel.record(action, changed)
+
+	// an operator changing the modes from outside sees the change too
+	channel.broadcast(action, outgoing)
+	if channel.getUserByNick(action.OriginNick) == nil {
+		action.Response.SendOutgoing(outgoing)
+	}
+}
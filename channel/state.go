@@ -0,0 +1,90 @@
+package channel
+
+import (
+	"github.com/jukeks/channeld/history"
+	"github.com/jukeks/channeld/protocol"
+	"github.com/jukeks/channeld/store"
+
+	"log"
+	"strings"
+)
+
+// ChannelState is what is kept of a channel when it is saved or handed
+// over to a new process.
+type ChannelState struct {
+	Name    string
+	Mode    string
+	Members []MemberState
+}
+
+type MemberState struct {
+	Nick     string
+	Hostmask string
+}
+
+// State returns the channel's state. The channel must not be served while
+// it is called.
+func (channel *Channel) State() ChannelState {
+	state := ChannelState{channel.Name, channel.mode, []MemberState{}}
+	for _, u := range channel.users {
+		state.Members = append(state.Members, MemberState{u.nick, u.hostmask})
+	}
+
+	return state
+}
+
+// RestoreChannel recreates a channel from its state. Members without a
+// connection in conns are left out.
+func RestoreChannel(state ChannelState, store store.ChannelStore,
+	history history.Store, empty chan<- *Channel,
+	conns map[string]*protocol.IrcConnection) *Channel {
+	c := NewChannel(state.Name, store, history, empty)
+	c.mode = state.Mode
+
+	for _, member := range state.Members {
+		conn, ok := conns[member.Nick]
+		if !ok {
+			continue
+		}
+
+		c.users = append(c.users, &ChannelUser{member.Nick, member.Hostmask,
+			conn})
+	}
+
+	return c
+}
+
+// Permanent channels outlive their members and have their metadata kept in
+// the channel store.
+func (channel *Channel) Permanent() bool {
+	return strings.ContainsRune(channel.mode, 'P')
+}
+
+func (channel *Channel) Metadata() store.ChannelMetadata {
+	return store.ChannelMetadata{channel.Name, channel.mode}
+}
+
+// NewChannelFromMetadata recreates a stored channel with no members.
+func NewChannelFromMetadata(metadata store.ChannelMetadata,
+	store store.ChannelStore, history history.Store,
+	empty chan<- *Channel) *Channel {
+	c := NewChannel(metadata.Name, store, history, empty)
+	c.mode = metadata.Mode
+
+	return c
+}
+
+// persist records a change to the channel's metadata, to be called from
+// the channel's goroutine after the change.
+func (channel *Channel) persist() {
+	var err error
+	if channel.Permanent() {
+		err = channel.store.Save(channel.Metadata())
+	} else {
+		err = channel.store.Delete(channel.Name)
+	}
+
+	if err != nil {
+		log.Printf("Persisting channel %s failed: %v", channel.Name, err)
+	}
+}
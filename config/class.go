@@ -0,0 +1,107 @@
+package config
+
+import (
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+const DefaultClass = "default"
+
+// Class holds the limits shared by a group of client connections.
+type Class struct {
+	Name string
+
+	// Masks select the connections belonging to the class, either as CIDR
+	// blocks or as glob patterns matched against the address. They match
+	// the IP address only, as the class is picked before the hostname is
+	// looked up. A class with no masks takes everyone.
+	Masks []string
+
+	// MaxClients limits the connections in the class, MaxPerIP those from
+	// a single address, or from a single /64 for IPv6. Zero is unlimited.
+	MaxClients int
+	MaxPerIP   int
+
+	// PingFrequency overrides the server wide ping frequency when set.
+	PingFrequency time.Duration
+
+	// SendQ is the number of bytes that may wait in a connection's write
+	// queue before the client is dropped, RecvQ the same for the lines
+	// received from the client that wait to be handled. No line may be
+	// longer than RecvQ either.
+	SendQ int
+	RecvQ int
+
+	// Flood control: a client may send FloodBurst commands at once, after
+	// which it earns FloodRate commands per second. Clients going over are
+	// slowed down, and dropped once they are ExcessFlood commands behind.
+	FloodBurst  float64
+	FloodRate   float64
+	ExcessFlood float64
+
+	// MOTD overrides the server wide message of the day file when set.
+	MOTD string
+}
+
+func (class *Class) Matches(ip net.IP) bool {
+	if len(class.Masks) == 0 {
+		return true
+	}
+
+	for _, mask := range class.Masks {
+		if strings.Contains(mask, "/") {
+			_, network, err := net.ParseCIDR(mask)
+			if err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+
+			continue
+		}
+
+		if ok, _ := path.Match(mask, ip.String()); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (class *Class) GetPingFrequency() time.Duration {
+	if class.PingFrequency > 0 {
+		return class.PingFrequency
+	}
+
+	return Config.PingFrequency
+}
+
+func (class *Class) GetMOTD() string {
+	if class != nil && class.MOTD != "" {
+		return class.MOTD
+	}
+
+	return Config.MOTD
+}
+
+func (c *Configuration) GetClass(name string) *Class {
+	for _, class := range c.Classes {
+		if class.Name == name {
+			return class
+		}
+	}
+
+	return nil
+}
+
+// ClassFor picks the first class in configuration order whose masks match
+// the address, falling back to the default class.
+func (c *Configuration) ClassFor(ip net.IP) *Class {
+	for _, class := range c.Classes {
+		if class.Matches(ip) {
+			return class
+		}
+	}
+
+	return c.GetClass(DefaultClass)
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"net"
+	"testing"
+)
+
+func TestClassMatches(t *testing.T) {
+	class := &Class{Masks: []string{"192.0.2.0/24", "198.51.100.*",
+		"2001:db8::/32"}}
+
+	assert.True(t, class.Matches(net.ParseIP("192.0.2.10")), "CIDR not matched")
+	assert.True(t, class.Matches(net.ParseIP("198.51.100.7")),
+		"Glob not matched")
+	assert.True(t, class.Matches(net.ParseIP("2001:db8::1")),
+		"IPv6 CIDR not matched")
+	assert.False(t, class.Matches(net.ParseIP("203.0.113.1")),
+		"Other address matched")
+	assert.False(t, class.Matches(nil), "Unix socket matched")
+
+	assert.True(t, (&Class{}).Matches(nil), "Class without masks not open")
+}
+
+func TestClassFor(t *testing.T) {
+	defer func(classes []*Class) { Config.Classes = classes }(Config.Classes)
+
+	local := &Class{Name: "local", Masks: []string{"127.0.0.0/8"}}
+	wide := &Class{Name: "wide", Masks: []string{"127.*"}}
+	Config.Classes = []*Class{local, wide, {Name: DefaultClass}}
+
+	assert.Equal(t, Config.ClassFor(net.ParseIP("127.0.0.1")), local,
+		"First matching class not picked")
+	assert.Equal(t, Config.ClassFor(net.ParseIP("192.0.2.1")).Name,
+		DefaultClass, "Default class not picked")
+	assert.Equal(t, Config.GetClass("wide"), wide, "Class not found by name")
+}
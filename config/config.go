@@ -1,7 +1,151 @@
 package config
 
+import (
+	"time"
+)
+
+// Listener is an address clients connect to. Network is "tcp", "tcp4",
+// "tcp6" or "unix". Listeners with a certificate speak TLS, and Class, if
+// set, overrides the address based class of their clients.
+type Listener struct {
+	Network string
+	Address string
+	Class   string
+	TLSCert string
+	TLSKey  string
+
+	// WebSocket listeners serve browsers over HTTP. Origins restricts the
+	// pages allowed to connect, and the client address is taken from the
+	// forwarding headers of requests coming from TrustedProxies.
+	WebSocket bool
+	Origins   []string
+
+	// ProxyProtocol listeners only take connections from TrustedProxies,
+	// which must start them with a PROXY protocol v1 or v2 header.
+	ProxyProtocol  bool
+	TrustedProxies []string
+}
+
+// Oper is an operator account, usable from user@host masks in Hosts or
+// from anywhere if there are none.
+type Oper struct {
+	Name     string
+	Password string
+	Hosts    []string
+}
+
 type Configuration struct {
 	ServerID string
+
+	// PingFrequency is how long a connection may stay silent before the
+	// server sends it a PING, PingTimeout how long it then has to answer.
+	PingFrequency time.Duration
+	PingTimeout   time.Duration
+
+	// RegistrationTimeout is how long a new connection has to register,
+	// capability negotiation included.
+	RegistrationTimeout time.Duration
+
+	// DNSTimeout bounds the hostname lookup of a new client, successful
+	// and failed lookups are remembered for DNSCacheTTL.
+	DNSTimeout  time.Duration
+	DNSCacheTTL time.Duration
+
+	// Ident enables RFC 1413 lookups of new clients connecting directly.
+	// Usernames that could not be verified are shown with a '~' prefix,
+	// which counts towards UserLen.
+	Ident        bool
+	IdentPort    int
+	IdentTimeout time.Duration
+	UserLen      int
+
+	Listeners []Listener
+
+	// MaxClients is the total number of connections the server accepts.
+	MaxClients int
+	Classes    []*Class
+
+	// CommandCosts weighs commands for flood control, anything not listed
+	// costs 1. Clients from FloodExemptHosts are not throttled at all.
+	CommandCosts     map[string]float64
+	FloodExemptHosts []string
+
+	Opers []Oper
+
+	// AdminLocation, AdminLocation2 and AdminEmail are what ADMIN tells
+	// about the server and its administrators, Info the lines INFO shows.
+	AdminLocation  string
+	AdminLocation2 string
+	AdminEmail     string
+	Info           []string
+
+	// MOTD is the file the message of the day is read from, at startup
+	// and on rehash. Classes may have their own, which is how listeners
+	// get one through the class they assign.
+	MOTD string
+
+	// ChannelStore is the file permanent channels are saved in, they are
+	// not saved at all if it is empty.
+	ChannelStore string
+
+	// HistoryLength is the number of messages kept per channel and per
+	// private conversation, none of them for longer than HistoryMaxAge.
+	// A CHATHISTORY query returns at most HistoryQueryLimit messages.
+	HistoryLength     int
+	HistoryMaxAge     time.Duration
+	HistoryQueryLimit int
+
+	// MonitorLimit is the number of nicks a client may MONITOR.
+	MonitorLimit int
+
+	// ShutdownTimeout is how long clients get to receive their queued
+	// messages when the server is shutting down.
+	ShutdownTimeout time.Duration
+}
+
+var Config Configuration = Configuration{
+	ServerID:            "",
+	PingFrequency:       90 * time.Second,
+	PingTimeout:         120 * time.Second,
+	RegistrationTimeout: 60 * time.Second,
+	DNSTimeout:          5 * time.Second,
+	DNSCacheTTL:         10 * time.Minute,
+	Ident:               false,
+	IdentPort:           113,
+	IdentTimeout:        5 * time.Second,
+	UserLen:             10,
+	Listeners: []Listener{
+		{Network: "tcp", Address: ":6667"},
+	},
+	MaxClients: 8192,
+	Classes: []*Class{
+		{Name: "local", Masks: []string{"127.0.0.0/8", "::1/128"},
+			SendQ: 512 * 1024, RecvQ: 8192,
+			FloodBurst: 10, FloodRate: 1, ExcessFlood: 20},
+		{Name: DefaultClass, MaxPerIP: 10,
+			SendQ: 512 * 1024, RecvQ: 8192,
+			FloodBurst: 10, FloodRate: 1, ExcessFlood: 20},
+	},
+	CommandCosts: map[string]float64{
+		"PONG": 0.5,
+		"NICK": 2,
+		"JOIN": 2,
+	},
+	FloodExemptHosts:  []string{},
+	Opers:             []Oper{},
+	HistoryLength:     1000,
+	HistoryMaxAge:     7 * 24 * time.Hour,
+	HistoryQueryLimit: 100,
+	MonitorLimit:      100,
+	ShutdownTimeout:   10 * time.Second,
 }
 
-var Config Configuration = Configuration{""}
+func (c *Configuration) GetOper(name string) *Oper {
+	for i := range c.Opers {
+		if c.Opers[i].Name == name {
+			return &c.Opers[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package history
+
+import (
+	"strings"
+	"time"
+)
+
+// Entry is a recorded message. Message is the serialized message without
+// its prefix, Source the prefix.
+type Entry struct {
+	ID      string
+	Time    time.Time
+	Source  string
+	Command string
+	Message string
+}
+
+// Target is a conversation and the time of its latest entry.
+type Target struct {
+	Key    string
+	Latest time.Time
+}
+
+// Store keeps the history of channels and private conversations, each
+// under its own key: the channel name or the PrivateKey of the two users.
+// Implementations are safe for concurrent use.
+type Store interface {
+	Add(key string, entry Entry)
+
+	// Find returns the entry with the given id.
+	Find(key, id string) (Entry, bool)
+
+	// Range returns at most limit entries accepted by the filter that are
+	// newer than start and older than end, oldest first. If there are more,
+	// the latest ones are returned when latest is set and the earliest ones
+	// otherwise.
+	Range(key string, start, end time.Time, limit int, latest bool,
+		filter func(Entry) bool) []Entry
+
+	// Targets returns the keys with entries between start and end along
+	// with their latest entry, for keys accepted by the filter.
+	Targets(start, end time.Time, limit int,
+		filter func(key string) bool) []Target
+
+	// Delete drops all entries under the key.
+	Delete(key string)
+}
+
+// PrivateKey is the key of the conversation between two users, given by
+// ids that are never reused. It is the same both ways.
+func PrivateKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+
+	return a + " " + b
+}
+
+// PrivateKeyOwner tells whether the conversation is one of the user's, and
+// the id of the other party.
+func PrivateKeyOwner(key, id string) (string, bool) {
+	parts := strings.Split(key, " ")
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	switch id {
+	case parts[0]:
+		return parts[1], true
+	case parts[1]:
+		return parts[0], true
+	default:
+		return "", false
+	}
+}
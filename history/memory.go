@@ -0,0 +1,158 @@
+package history
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps at most MaxEntries entries per key, none older than
+// MaxAge.
+type MemoryStore struct {
+	MaxEntries int
+	MaxAge     time.Duration
+
+	mutex   sync.Mutex
+	entries map[string][]Entry
+}
+
+func NewMemoryStore(maxEntries int, maxAge time.Duration) *MemoryStore {
+	s := new(MemoryStore)
+	s.MaxEntries = maxEntries
+	s.MaxAge = maxAge
+	s.entries = make(map[string][]Entry)
+
+	return s
+}
+
+func (s *MemoryStore) Add(key string, entry Entry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := s.entries[key]
+
+	// keep the times strictly increasing so that they can be used as
+	// selectors
+	if n := len(entries); n > 0 && !entry.Time.After(entries[n-1].Time) {
+		entry.Time = entries[n-1].Time.Add(time.Nanosecond)
+	}
+	entries = append(entries, entry)
+
+	cutoff := time.Now().Add(-s.MaxAge)
+	drop := 0
+	for drop < len(entries) && (len(entries)-drop > s.MaxEntries ||
+		entries[drop].Time.Before(cutoff)) {
+		drop++
+	}
+
+	s.entries[key] = append([]Entry{}, entries[drop:]...)
+}
+
+func (s *MemoryStore) Find(key, id string) (Entry, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, entry := range s.entries[key] {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+
+	return Entry{}, false
+}
+
+func (s *MemoryStore) Range(key string, start, end time.Time, limit int,
+	latest bool, filter func(Entry) bool) []Entry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := s.entries[key]
+	first := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Time.After(start)
+	})
+	last := sort.Search(len(entries), func(i int) bool {
+		return !entries[i].Time.Before(end)
+	})
+
+	result := []Entry{}
+	for i := 0; i < last-first && len(result) < limit; i++ {
+		entry := entries[first+i]
+		if latest {
+			entry = entries[last-1-i]
+		}
+
+		if filter == nil || filter(entry) {
+			result = append(result, entry)
+		}
+	}
+
+	if latest {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+
+	return result
+}
+
+func (s *MemoryStore) Targets(start, end time.Time, limit int,
+	filter func(key string) bool) []Target {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	targets := []Target{}
+	for key, entries := range s.entries {
+		if !filter(key) {
+			continue
+		}
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			t := entries[i].Time
+			if t.After(start) && t.Before(end) {
+				targets = append(targets, Target{key, t})
+				break
+			}
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Latest.Before(targets[j].Latest)
+	})
+
+	if len(targets) > limit {
+		targets = targets[:limit]
+	}
+
+	return targets
+}
+
+// Export returns every entry by key, to be handed to Import.
+func (s *MemoryStore) Export() map[string][]Entry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := make(map[string][]Entry, len(s.entries))
+	for key, e := range s.entries {
+		entries[key] = append([]Entry{}, e...)
+	}
+
+	return entries
+}
+
+// Import replaces the entries with ones exported before.
+func (s *MemoryStore) Import(entries map[string][]Entry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries = make(map[string][]Entry, len(entries))
+	for key, e := range entries {
+		s.entries[key] = append([]Entry{}, e...)
+	}
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.entries, key)
+}
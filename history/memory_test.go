@@ -0,0 +1,78 @@
+package history
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+	"time"
+)
+
+func ids(entries []Entry) []string {
+	result := []string{}
+	for _, e := range entries {
+		result = append(result, e.ID)
+	}
+
+	return result
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(4, time.Hour)
+	base := time.Now()
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		s.Add("#x", Entry{ID: id, Time: base.Add(time.Duration(i) * time.Second)})
+	}
+
+	all := s.Range("#x", time.Time{}, base.Add(time.Hour), 10, true, nil)
+	assert.Equal(t, ids(all), []string{"b", "c", "d", "e"},
+		"Oldest entry not dropped")
+
+	c, ok := s.Find("#x", "c")
+	assert.True(t, ok, "Entry not found")
+	assert.Equal(t, ids(s.Range("#x", time.Time{}, c.Time, 10, true, nil)),
+		[]string{"b"}, "Range end not exclusive")
+	assert.Equal(t, ids(s.Range("#x", c.Time, base.Add(time.Hour), 1, false, nil)),
+		[]string{"d"}, "Earliest entries not picked")
+	assert.Equal(t, ids(s.Range("#x", c.Time, base.Add(time.Hour), 1, true, nil)),
+		[]string{"e"}, "Latest entries not picked")
+
+	s.Add("#x", Entry{ID: "j", Command: "JOIN", Time: base.Add(9 * time.Second)})
+	messages := s.Range("#x", c.Time, base.Add(time.Hour), 2, true,
+		func(e Entry) bool { return e.Command != "JOIN" })
+	assert.Equal(t, ids(messages), []string{"d", "e"}, "Filter not applied")
+
+	s.Add("a b", Entry{ID: "f", Time: base.Add(10 * time.Second)})
+	targets := s.Targets(time.Time{}, base.Add(time.Hour), 10,
+		func(key string) bool { return true })
+	assert.Equal(t, targets, []Target{{"#x", base.Add(9 * time.Second)},
+		{"a b", base.Add(10 * time.Second)}}, "Targets listed incorrectly")
+
+	s.Delete("#x")
+	assert.Equal(t, len(s.Range("#x", time.Time{}, base.Add(time.Hour), 10,
+		true, nil)), 0, "Deleted entries kept")
+}
+
+func TestPrivateKey(t *testing.T) {
+	assert.Equal(t, PrivateKey("b7", "a3"), PrivateKey("a3", "b7"),
+		"Private key depends on direction")
+
+	other, ok := PrivateKeyOwner(PrivateKey("b7", "a3"), "b7")
+	assert.True(t, ok, "Owner not recognized")
+	assert.Equal(t, other, "a3", "Other party not found")
+
+	_, ok = PrivateKeyOwner(PrivateKey("b7", "a3"), "c1")
+	assert.False(t, ok, "Outsider taken for an owner")
+}
+
+func TestMemoryStoreExport(t *testing.T) {
+	s := NewMemoryStore(4, time.Hour)
+	s.Add("#x", Entry{ID: "a", Time: time.Now()})
+
+	exported := s.Export()
+	restored := NewMemoryStore(4, time.Hour)
+	restored.Import(exported)
+
+	_, ok := restored.Find("#x", "a")
+	assert.True(t, ok, "Entry not imported")
+	assert.Equal(t, restored.Export(), exported, "Entries changed")
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"github.com/jukeks/channeld/config"
 	"github.com/jukeks/channeld/server"
 
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"net/http"
 	_ "net/http/pprof"
@@ -19,5 +24,32 @@ func main() {
 	log.Print("Starting server")
 
 	server := server.NewServer("example.example.com")
-	server.Serve()
+
+	signals, stop := signal.NotifyContext(context.Background(),
+		syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			log.Print("Received SIGHUP, rehashing")
+			server.Rehash()
+		}
+	}()
+
+	go func() {
+		<-signals.Done()
+		log.Print("Received signal, shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(),
+			config.Config.ShutdownTimeout)
+		defer cancel()
+
+		server.Shutdown(ctx)
+	}()
+
+	if err := server.Serve(); err != nil {
+		log.Fatal(err)
+	}
 }
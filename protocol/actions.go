@@ -10,6 +10,12 @@ const (
 type ClientAction struct {
 	Connection *IrcConnection
 	Message    IrcMessage
+	Metadata   Metadata
+
+	// Command is the command as the client sent it, upper-cased, and Size
+	// the number of bytes the line took, for usage statistics.
+	Command string
+	Size    int
 }
 
 type ConnectionInitiationAction struct {
@@ -31,4 +37,9 @@ type ChannelAction struct {
 	OriginNick     string
 	OriginConn     *IrcConnection
 	Message        IrcMessage
+	Metadata       Metadata
+
+	// Response takes the replies to the origin, the channel closes it once
+	// the action has been handled.
+	Response *Response
 }
@@ -0,0 +1,61 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+
+	"strconv"
+	"sync/atomic"
+)
+
+var batchCounter atomic.Uint64
+
+// Batch groups lines the client should handle together. The lines are
+// sent as Outgoing messages with their Batch set, between the ones
+// returned by Start and End. Connections without the batch capability get
+// the lines alone.
+//
+// References are unique to the process, so a batch may be sent to any
+// number of connections.
+type Batch struct {
+	Ref    string
+	Type   string
+	Params []string
+	Parent *Batch
+}
+
+func NewBatch(batchType string, params ...string) *Batch {
+	ref := strconv.FormatUint(batchCounter.Add(1), 36)
+	return &Batch{ref, batchType, params, nil}
+}
+
+// Nested starts a batch within this one.
+func (b *Batch) Nested(batchType string, params ...string) *Batch {
+	nested := NewBatch(batchType, params...)
+	nested.Parent = b
+
+	return nested
+}
+
+func (b *Batch) Start() *Outgoing {
+	o := NewOutgoing(config.Config.ServerID,
+		BatchMessage{"+" + b.Ref, b.Type, b.Params}, Metadata{})
+	o.Batch = b.Parent
+
+	return o
+}
+
+func (b *Batch) End() *Outgoing {
+	o := NewOutgoing(config.Config.ServerID, BatchMessage{"-" + b.Ref, "", nil},
+		Metadata{})
+	o.Batch = b.Parent
+
+	return o
+}
+
+// Line wraps a message from the server in the batch.
+func (b *Batch) Line(message IrcMessage) *Outgoing {
+	o := NewOutgoing(config.Config.ServerID, message, Metadata{})
+	o.Batch = b
+
+	return o
+}
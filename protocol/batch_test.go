@@ -0,0 +1,43 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	config.Config.ServerID = "irc.example"
+	outer := NewBatch("netsplit", "a.example", "b.example")
+	inner := outer.Nested("chathistory", "#x")
+	line := NewOutgoing("a!b@c", PrivateMessage{"#x", "hi"}, Metadata{})
+	line.Batch = inner
+
+	lines := []*Outgoing{outer.Start(), inner.Start(), line, inner.End(),
+		outer.End()}
+
+	serialize := func(caps Capability) []string {
+		result := []string{}
+		for _, o := range lines {
+			if l := o.Serialize(caps); l != "" {
+				result = append(result, l)
+			}
+		}
+
+		return result
+	}
+
+	id := ":irc.example"
+	assert.Equal(t, serialize(CapBatch), []string{
+		id + " BATCH +" + outer.Ref + " netsplit a.example b.example",
+		"@batch=" + outer.Ref + " " + id + " BATCH +" + inner.Ref +
+			" chathistory #x",
+		"@batch=" + inner.Ref + " :a!b@c PRIVMSG #x :hi",
+		"@batch=" + outer.Ref + " " + id + " BATCH -" + inner.Ref,
+		id + " BATCH -" + outer.Ref,
+	}, "Nested batch serialized incorrectly")
+
+	assert.Equal(t, serialize(0), []string{":a!b@c PRIVMSG #x :hi"},
+		"Batch sent without the capability")
+}
@@ -0,0 +1,132 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+
+	"fmt"
+	"strings"
+)
+
+// Capability is a set of IRCv3 capabilities.
+type Capability uint32
+
+const (
+	CapMessageTags Capability = 1 << iota
+	CapServerTime
+	CapBatch
+	CapEchoMessage
+	CapLabeledResponse
+	CapExtendedJoin
+	CapChgHost
+	CapSetName
+	CapChatHistory
+	CapAccountNotify
+)
+
+// capabilityNames lists the capabilities offered to clients.
+var capabilityNames = []struct {
+	name string
+	cap  Capability
+}{
+	{"message-tags", CapMessageTags},
+	{"server-time", CapServerTime},
+	{"batch", CapBatch},
+	{"echo-message", CapEchoMessage},
+	{"labeled-response", CapLabeledResponse},
+	{"extended-join", CapExtendedJoin},
+	{"account-notify", CapAccountNotify},
+	{"chghost", CapChgHost},
+	{"setname", CapSetName},
+	{"draft/chathistory", CapChatHistory},
+}
+
+func (caps Capability) Has(cap Capability) bool {
+	return caps&cap == cap
+}
+
+func (caps Capability) String() string {
+	names := []string{}
+	for _, c := range capabilityNames {
+		if caps.Has(c.cap) {
+			names = append(names, c.name)
+		}
+	}
+
+	return strings.Join(names, " ")
+}
+
+func (conn *IrcConnection) Capabilities() Capability {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return conn.caps
+}
+
+func (conn *IrcConnection) SetCapabilities(caps Capability) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.caps = caps
+}
+
+// parseCapabilities reads a CAP REQ list, where a '-' prefix asks for a
+// capability to be removed.
+func parseCapabilities(list string) (add, remove Capability, ok bool) {
+	for _, name := range strings.Fields(list) {
+		disable := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		found := false
+		for _, c := range capabilityNames {
+			if c.name != name {
+				continue
+			}
+
+			found = true
+			if disable {
+				remove |= c.cap
+			} else {
+				add |= c.cap
+			}
+		}
+
+		if !found {
+			return 0, 0, false
+		}
+	}
+
+	return add, remove, true
+}
+
+// CapReply answers a CAP command, before or after registration. A request
+// is granted or refused as a whole. CAP END needs no answer.
+func (conn *IrcConnection) CapReply(message CapMessage, nick string) string {
+	id := config.Config.ServerID
+
+	switch message.Subcommand {
+	case "LS":
+		all := Capability(0)
+		for _, c := range capabilityNames {
+			all |= c.cap
+		}
+
+		return fmt.Sprintf(":%s CAP %s LS :%s", id, nick, all)
+	case "LIST":
+		return fmt.Sprintf(":%s CAP %s LIST :%s", id, nick,
+			conn.Capabilities())
+	case "REQ":
+		add, remove, ok := parseCapabilities(message.Params)
+		if !ok {
+			return fmt.Sprintf(":%s CAP %s NAK :%s", id, nick,
+				message.Params)
+		}
+
+		conn.SetCapabilities(conn.Capabilities()&^remove | add)
+		return fmt.Sprintf(":%s CAP %s ACK :%s", id, nick, message.Params)
+	case "END":
+		return ""
+	default:
+		return NewNumeric(ERR_INVALIDCAPCMD, nick,
+			message.Subcommand).Serialize()
+	}
+}
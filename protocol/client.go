@@ -1,7 +1,11 @@
 package protocol
 
 import (
+	"github.com/jukeks/channeld/config"
+
 	"bufio"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"strings"
@@ -9,34 +13,95 @@ import (
 	"time"
 )
 
+var errRecvQExceeded = errors.New("receive queue full")
+
 type IrcConnection struct {
 	conn   net.Conn
 	reader *bufio.Reader
-
-	mutex  sync.Mutex
-	closed bool
-
-	messageCounter uint32
-	counterReseted time.Time
+	class  *config.Class
+
+	mutex       sync.Mutex
+	closed      bool
+	closeReason string
+
+	// writeMutex keeps the lines written from different goroutines whole
+	writeMutex sync.Mutex
+
+	caps Capability
+
+	bucket      floodBucket
+	floodExempt bool
+
+	lastActivity time.Time
+	pingToken    string
+	pingSent     time.Time
+	lag          time.Duration
+
+	// sendq holds the lines waiting for the writer, sendqBytes their size
+	// on the wire.
+	sendq      []string
+	sendqBytes int
+	stats      ConnectionStats
+
+	// registered is set once the client has registered, which is when the
+	// writer is started
+	registered bool
+
+	// recvq holds the lines received from the client that have not been
+	// handled yet, recvqBytes their size. readErr is the error that stopped
+	// the receiver.
+	recvq      []string
+	recvqBytes int
+	readErr    error
+	received   chan bool
+
+	// set while the connection is being handed over to a new process,
+	// partialLine keeps what was read of an unfinished line
+	detached     bool
+	partialLine  []byte
+	receiverDone chan bool
+	readerDone   chan bool
+	writerDone   chan bool
 
 	incoming chan ClientAction
-	outgoing chan string
+	outgoing chan bool
 	quit     chan bool
 }
 
-func NewIrcConnection(conn net.Conn,
+// ConnectionStats are the traffic counters kept for monitoring.
+type ConnectionStats struct {
+	Connected        time.Time
+	SendQ            int
+	MaxSendQ         int
+	BytesSent        uint64
+	BytesReceived    uint64
+	MessagesSent     uint64
+	MessagesReceived uint64
+
+	// Lag is the round trip time measured by the last answered PING.
+	Lag time.Duration
+}
+
+func NewIrcConnection(conn net.Conn, class *config.Class,
 	incoming chan ClientAction) *IrcConnection {
 	c := new(IrcConnection)
 
 	c.conn = conn
-	c.reader = bufio.NewReader(conn)
+	c.class = class
+	c.reader = bufio.NewReaderSize(conn, class.RecvQ)
 	c.mutex = sync.Mutex{}
 
-	c.counterReseted = time.Now()
+	c.bucket = floodBucket{class.FloodBurst, time.Now()}
+	c.lastActivity = time.Now()
+	c.stats.Connected = time.Now()
 
 	c.incoming = incoming
-	c.outgoing = make(chan string, 1000)
-	c.quit = make(chan bool, 2)
+	c.outgoing = make(chan bool, 1)
+	c.quit = make(chan bool)
+	c.received = make(chan bool, 1)
+	c.receiverDone = make(chan bool)
+	c.readerDone = make(chan bool)
+	c.writerDone = make(chan bool)
 
 	return c
 }
@@ -49,18 +114,108 @@ func (conn *IrcConnection) Close() {
 		conn.closed = true
 
 		conn.conn.Close()
-		conn.quit <- true
-		conn.quit <- true
+		close(conn.quit)
 	}
 }
 
+// Quit queues a final ERROR line and closes the connection once the write
+// queue has drained, or at the deadline at the latest.
+func (conn *IrcConnection) Quit(message string, deadline time.Time) {
+	conn.mutex.Lock()
+	registered := conn.registered
+	conn.mutex.Unlock()
+
+	// nothing is queued before registration, nor would it be written, so
+	// the line is written right away and cut short at the deadline
+	if !registered {
+		timer := time.AfterFunc(time.Until(deadline), conn.Close)
+		defer timer.Stop()
+
+		conn.write(fmt.Sprintf("ERROR :%s", message))
+		conn.Close()
+		return
+	}
+
+	conn.Send(fmt.Sprintf("ERROR :%s", message))
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for time.Now().Before(deadline) {
+		conn.mutex.Lock()
+		drained := conn.sendqBytes == 0
+		conn.mutex.Unlock()
+
+		if drained {
+			break
+		}
+
+		select {
+		case <-ticker.C:
+		case <-conn.quit:
+			return
+		}
+	}
+
+	conn.Close()
+}
+
+// Disconnect tells the client why it is being dropped and hands the
+// connection over to the server for removal. The reason is kept so the
+// server can use it as the quit message.
+func (conn *IrcConnection) Disconnect(reason string) {
+	conn.mutex.Lock()
+	if conn.closed || conn.closeReason != "" {
+		conn.mutex.Unlock()
+		return
+	}
+	conn.closeReason = reason
+	conn.mutex.Unlock()
+
+	log.Printf("Disconnecting %v: %s", conn, reason)
+
+	// the client may well be the reason the writer is stuck, so the last
+	// words are written from their own goroutine, once the line being
+	// written is out
+	go func() {
+		conn.write(fmt.Sprintf("ERROR :Closing Link: (%s)", reason))
+		conn.incoming <- ClientAction{conn, nil, Metadata{}, "", 0}
+	}()
+}
+
+func (conn *IrcConnection) CloseReason() string {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return conn.closeReason
+}
+
 func (conn *IrcConnection) Send(msg string) {
-	select {
-	case conn.outgoing <- msg:
+	size := len(msg) + 2
+
+	conn.mutex.Lock()
+	if conn.closed || conn.closeReason != "" {
+		conn.mutex.Unlock()
 		return
-	default:
+	}
+
+	if conn.sendqBytes+size > conn.class.SendQ {
+		conn.mutex.Unlock()
 		log.Printf("Client %v queue is full. Closing.", conn)
-		conn.incoming <- ClientAction{conn, nil}
+		conn.Disconnect("Max SendQ exceeded")
+		return
+	}
+
+	conn.sendq = append(conn.sendq, msg)
+	conn.sendqBytes += size
+	if conn.sendqBytes > conn.stats.MaxSendQ {
+		conn.stats.MaxSendQ = conn.sendqBytes
+	}
+	conn.mutex.Unlock()
+
+	select {
+	case conn.outgoing <- true:
+	default:
 	}
 }
 
@@ -72,41 +227,72 @@ func (conn *IrcConnection) SendMessageFrom(from string, message IrcMessage) {
 	conn.Send(GetSerializedMessageFrom(from, message))
 }
 
-/*----------------------------------------------------------------------------*/
+func (conn *IrcConnection) Stats() ConnectionStats {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
 
-func (conn *IrcConnection) checkCounter() bool {
-	if time.Now().After(conn.counterReseted.Add(10 * time.Second)) {
-		conn.messageCounter = 0
-		conn.counterReseted = time.Now()
-	}
+	stats := conn.stats
+	stats.SendQ = conn.sendqBytes
+	stats.Lag = conn.lag
+
+	return stats
+}
 
-	conn.messageCounter += 1
-	if conn.messageCounter > 10 {
-		return false
+// String identifies the connection in logs by the client's address.
+func (conn *IrcConnection) String() string {
+	if conn.conn == nil {
+		return "<unconnected>"
 	}
 
-	return true
+	return conn.conn.RemoteAddr().String()
 }
 
-func (conn *IrcConnection) getHostname() string {
-	split := strings.Split(conn.conn.RemoteAddr().String(), ":")
-	remote := split[0]
+func (conn *IrcConnection) Class() *config.Class {
+	return conn.class
+}
+
+// Closed returns a channel that is closed along with the connection.
+func (conn *IrcConnection) Closed() <-chan bool {
+	return conn.quit
+}
 
-	names, _ := net.LookupAddr(remote)
-	if len(names) > 0 {
-		remote = names[0]
+/*----------------------------------------------------------------------------*/
+
+func (conn *IrcConnection) RemoteIP() net.IP {
+	host, _, err := net.SplitHostPort(conn.conn.RemoteAddr().String())
+	if err != nil {
+		return nil
 	}
 
-	return remote
+	return net.ParseIP(host)
+}
+
+// authNotice writes a notice to a client that has not registered yet.
+func (conn *IrcConnection) authNotice(text string) {
+	conn.write(fmt.Sprintf(":%s NOTICE * :%s", config.Config.ServerID, text))
 }
 
 func (conn *IrcConnection) Serve(newClients chan ConnectionInitiationAction) {
+	go conn.keepaliveRoutine()
+	go conn.receiverRoutine()
+
 	succ := conn.handshake(newClients)
 	if !succ {
 		log.Printf("Handshake failed")
+		conn.Close()
 		return
 	}
 
+	conn.serveRegistered()
+}
+
+func (conn *IrcConnection) serveRegistered() {
+	defer close(conn.readerDone)
+
+	conn.mutex.Lock()
+	conn.registered = true
+	conn.mutex.Unlock()
+
 	go conn.writerRoutine()
 
 	for {
@@ -116,52 +302,165 @@ func (conn *IrcConnection) Serve(newClients chan ConnectionInitiationAction) {
 		default:
 		}
 
-		message, err := conn.readMessage()
+		action, err := conn.readMessage()
 		if err != nil {
-			log.Printf("read failed: %v", err)
-			conn.incoming <- ClientAction{conn, nil}
-			return
-		}
+			if conn.isDetached() {
+				return
+			}
 
-		if !conn.checkCounter() {
-			log.Printf("Client flooding %d messages in %s",
-				conn.messageCounter,
-				time.Since(conn.counterReseted).String())
-			conn.incoming <- ClientAction{conn, nil}
+			log.Printf("read failed: %v", err)
+			conn.incoming <- ClientAction{conn, nil, Metadata{}, "", 0}
 			return
 		}
 
-		conn.incoming <- ClientAction{conn, message}
+		conn.incoming <- action
 	}
 }
 
 func (conn *IrcConnection) writerRoutine() {
+	defer close(conn.writerDone)
+
 	for {
 		select {
-		case msg := <-conn.outgoing:
-			conn.write(msg)
+		case <-conn.outgoing:
+			conn.flush()
 		case <-conn.quit:
 			return
 		}
 	}
 }
 
-func (conn *IrcConnection) write(message string) {
+func (conn *IrcConnection) flush() {
+	for {
+		conn.mutex.Lock()
+		if len(conn.sendq) == 0 {
+			conn.mutex.Unlock()
+			return
+		}
+
+		msg := conn.sendq[0]
+		conn.sendq = conn.sendq[1:]
+		conn.mutex.Unlock()
+
+		err := conn.write(msg)
+
+		conn.mutex.Lock()
+		conn.sendqBytes -= len(msg) + 2
+		conn.mutex.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (conn *IrcConnection) write(message string) error {
+	conn.writeMutex.Lock()
 	err := WriteLine(conn.conn, message)
+	conn.writeMutex.Unlock()
+
 	if err != nil {
 		log.Printf("Error writing socket %v", err)
-		conn.incoming <- ClientAction{conn, nil}
-		return
+		conn.incoming <- ClientAction{conn, nil, Metadata{}, "", 0}
+		return err
 	}
 
+	conn.mutex.Lock()
+	conn.stats.BytesSent += uint64(len(message) + 2)
+	conn.stats.MessagesSent += 1
+	conn.mutex.Unlock()
+
 	log.Printf("Wrote: %s", message)
+	return nil
 }
 
-func (conn *IrcConnection) readMessage() (IrcMessage, error) {
-	line, err := ReadLine(conn.reader)
-	if err != nil {
-		return nil, err
+// receiverRoutine reads lines from the socket into the receive queue until
+// the read fails, so that the lines waiting to be handled are known.
+func (conn *IrcConnection) receiverRoutine() {
+	defer close(conn.receiverDone)
+
+	for {
+		line, err := conn.reader.ReadSlice('\n')
+
+		conn.mutex.Lock()
+		if err == nil {
+			conn.recvq = append(conn.recvq, string(line))
+			conn.recvqBytes += len(line)
+			conn.lastActivity = time.Now()
+			conn.stats.BytesReceived += uint64(len(line))
+			conn.stats.MessagesReceived += 1
+
+			if conn.recvqBytes > conn.class.RecvQ {
+				err = errRecvQExceeded
+			}
+		} else if conn.detached {
+			conn.partialLine = append([]byte{}, line...)
+		}
+		conn.readErr = err
+		conn.mutex.Unlock()
+
+		if err == bufio.ErrBufferFull || err == errRecvQExceeded {
+			conn.Disconnect("Max RecvQ exceeded")
+		}
+
+		select {
+		case conn.received <- true:
+		default:
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readLine takes the next line off the receive queue along with the number
+// of bytes it took, waiting for one if it is empty.
+func (conn *IrcConnection) readLine() (string, int, error) {
+	for {
+		conn.mutex.Lock()
+		if len(conn.recvq) > 0 {
+			line := conn.recvq[0]
+			conn.recvq = conn.recvq[1:]
+			conn.recvqBytes -= len(line)
+			conn.mutex.Unlock()
+
+			return strings.TrimRight(line, "\r\n"), len(line), nil
+		}
+
+		err := conn.readErr
+		conn.mutex.Unlock()
+
+		if err != nil {
+			return "", 0, err
+		}
+
+		<-conn.received
+	}
+}
+
+// readMessage reads the next message, which is given an id and a time
+// as it arrives. Empty lines are skipped, as RFC 1459 has them ignored.
+func (conn *IrcConnection) readMessage() (ClientAction, error) {
+	var tags Tags
+	var line string
+	size := 0
+	for line == "" {
+		read, n, err := conn.readLine()
+		if err != nil {
+			return ClientAction{}, err
+		}
+
+		tags, line = SplitTags(read)
+		line = strings.TrimLeft(line, " ")
+		size = n
+	}
+
+	if !conn.throttle(line) {
+		conn.Disconnect("Excess Flood")
+		return ClientAction{}, errors.New("excess flood")
 	}
 
-	return ParseMessage(line), nil
+	return ClientAction{conn, ParseMessage(line), NewMetadata(tags),
+		commandWord(line), size}, nil
 }
@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmptyLines(t *testing.T) {
+	class := &config.Class{RecvQ: 512, FloodBurst: 10, FloodRate: 1}
+	conn := newTestConnection(0)
+	conn.class = class
+	conn.bucket = floodBucket{class.FloodBurst, time.Now()}
+	conn.recvq = []string{"\r\n", "  \r\n", "@label=a\r\n", "PING :a\r\n"}
+	conn.readErr = io.EOF
+
+	action, err := conn.readMessage()
+	assert.Nil(t, err, "Read failed")
+	assert.Equal(t, action.Message, PingMessage{"a"}, "Empty lines not skipped")
+	assert.Equal(t, action.Command, "PING", "Command not kept")
+	assert.Equal(t, action.Size, 9, "Size not counted")
+}
+
+func TestSendQ(t *testing.T) {
+	class := &config.Class{SendQ: 100, RecvQ: 512}
+	socket, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewIrcConnection(socket, class, make(chan ClientAction, 10))
+	defer conn.Close()
+
+	for i := 0; i < 4; i++ {
+		conn.Send("PRIVMSG #x :" + strings.Repeat("a", 10))
+	}
+	assert.Equal(t, conn.Stats().SendQ, 96, "Lines not queued")
+	assert.Equal(t, conn.CloseReason(), "", "Client dropped within SendQ")
+
+	conn.Send("PRIVMSG #x :a")
+	assert.Equal(t, readTestLine(bufio.NewReader(client)),
+		"ERROR :Closing Link: (Max SendQ exceeded)", "Client not told")
+	assert.Equal(t, (<-conn.incoming).Message, nil, "Client not dropped")
+	assert.Equal(t, len(conn.sendq), 4, "Line queued over SendQ")
+}
+
+func TestRecvQ(t *testing.T) {
+	class := &config.Class{SendQ: 1 << 20, RecvQ: 64, FloodBurst: 100,
+		FloodRate: 100}
+
+	for _, input := range []string{
+		// nobody takes the lines off the incoming channel
+		strings.Repeat("PRIVMSG #x :abcdef\r\n", 10),
+		"PRIVMSG #x :" + strings.Repeat("a", 100) + "\r\n",
+	} {
+		socket, client := net.Pipe()
+		conn := NewIrcConnection(socket, class, make(chan ClientAction))
+		go conn.Resume()
+
+		go client.Write([]byte(input))
+		assert.Equal(t, readTestLine(bufio.NewReader(client)),
+			"ERROR :Closing Link: (Max RecvQ exceeded)", "Client not told")
+		assert.Equal(t, conn.CloseReason(), "Max RecvQ exceeded",
+			"Client not dropped")
+
+		conn.Close()
+		client.Close()
+	}
+}
@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+
+	"fmt"
+	"strings"
+)
+
+// Standard reply kinds.
+const (
+	FAIL = "FAIL"
+	WARN = "WARN"
+	NOTE = "NOTE"
+)
+
+// StandardReply is an IRCv3 standard reply about a command. The code and
+// context are for clients, the description for users.
+type StandardReply struct {
+	Kind        string
+	Command     string
+	Code        string
+	Context     []string
+	Description string
+}
+
+func (m StandardReply) GetType() MessageType {
+	return NUMERIC
+}
+
+func (m StandardReply) Serialize() string {
+	params := append([]string{m.Kind, m.Command, m.Code}, m.Context...)
+	return fmt.Sprintf(":%s %s :%s", config.Config.ServerID,
+		strings.Join(params, " "), m.Description)
+}
+
+func (r *Response) Fail(command, code, description string,
+	context ...string) {
+	r.SendMessage(StandardReply{FAIL, command, code, context, description})
+}
+
+func (r *Response) Warn(command, code, description string,
+	context ...string) {
+	r.SendMessage(StandardReply{WARN, command, code, context, description})
+}
+
+func (r *Response) Note(command, code, description string,
+	context ...string) {
+	r.SendMessage(StandardReply{NOTE, command, code, context, description})
+}
@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+
+	"log"
+	"math"
+	"time"
+)
+
+// floodBucket is a token bucket refilled at the class' FloodRate up to
+// FloodBurst tokens. Every command read from the client takes its cost out
+// of the bucket.
+type floodBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func commandCost(line string) float64 {
+	if cost, ok := config.Config.CommandCosts[commandWord(line)]; ok {
+		return cost
+	}
+
+	return 1
+}
+
+func (conn *IrcConnection) SetFloodExempt(exempt bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.floodExempt = exempt
+}
+
+// CheckFloodExemptHost exempts the connection from flood control if the
+// hostname matches one of the FloodExemptHosts.
+func (conn *IrcConnection) CheckFloodExemptHost(hostname string) {
+	for _, mask := range config.Config.FloodExemptHosts {
+		if MatchMask(mask, hostname) {
+			conn.SetFloodExempt(true)
+			return
+		}
+	}
+}
+
+func (conn *IrcConnection) isFloodExempt() bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return conn.floodExempt
+}
+
+// backlog is the number of lines received from the client that wait to be
+// handled.
+func (conn *IrcConnection) backlog() int {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return len(conn.recvq)
+}
+
+// throttle charges a line against the token bucket. A client that has run
+// the bucket dry is not disconnected, its reader just sleeps until the debt
+// has been paid back (fakelag). Only a client in debt with more than
+// ExcessFlood lines still waiting to be read is dropped.
+func (conn *IrcConnection) throttle(line string) bool {
+	if conn.isFloodExempt() {
+		return true
+	}
+
+	class := conn.class
+	bucket := &conn.bucket
+	now := time.Now()
+
+	elapsed := now.Sub(bucket.updated).Seconds()
+	bucket.tokens = math.Min(class.FloodBurst,
+		bucket.tokens+elapsed*class.FloodRate)
+	bucket.updated = now
+	bucket.tokens -= commandCost(line)
+
+	if bucket.tokens >= 0 {
+		return true
+	}
+
+	if backlog := conn.backlog(); float64(backlog) > class.ExcessFlood {
+		log.Printf("Client %v flooding, %d lines behind", conn, backlog)
+		return false
+	}
+
+	delay := time.Duration(-bucket.tokens / class.FloodRate *
+		float64(time.Second))
+
+	select {
+	case <-time.After(delay):
+		return true
+	case <-conn.quit:
+		return false
+	}
+}
@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFloodTestConnection(lines int) *IrcConnection {
+	class := &config.Class{RecvQ: 8192, FloodBurst: 5, FloodRate: 1000,
+		ExcessFlood: 10}
+
+	conn := newTestConnection(0)
+	conn.class = class
+	conn.quit = make(chan bool)
+	conn.bucket = floodBucket{class.FloodBurst, time.Now()}
+	for i := 0; i < lines; i++ {
+		conn.recvq = append(conn.recvq, "PRIVMSG #x :flood\r\n")
+	}
+	conn.readErr = io.EOF
+
+	return conn
+}
+
+// throttleAll handles the received lines of the connection like its reader
+// would, returning how many got through.
+func throttleAll(conn *IrcConnection) int {
+	read := 0
+	for {
+		line, _, err := conn.readLine()
+		if err != nil || !conn.throttle(line) {
+			return read
+		}
+		read++
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	conn := newFloodTestConnection(10)
+	assert.Equal(t, throttleAll(conn), 10, "Client dropped within ExcessFlood")
+
+	conn = newFloodTestConnection(30)
+	assert.Equal(t, throttleAll(conn), 5,
+		"Flooding client not dropped after the burst")
+}
+
+func TestExcessFlood(t *testing.T) {
+	class := &config.Class{SendQ: 1 << 20, RecvQ: 512, FloodBurst: 1,
+		FloodRate: 5, ExcessFlood: 3}
+	socket, client := net.Pipe()
+	defer client.Close()
+
+	incoming := make(chan ClientAction, 10)
+	conn := NewIrcConnection(socket, class, incoming)
+	go conn.Resume()
+	defer conn.Close()
+
+	go io.Copy(io.Discard, client)
+	go client.Write([]byte(strings.Repeat("PRIVMSG #x :flood\r\n", 10)))
+
+	handled := 0
+	for action := range incoming {
+		if action.Message == nil {
+			break
+		}
+		handled++
+	}
+
+	assert.True(t, handled < 10, "Flooding client not dropped")
+	assert.Equal(t, conn.CloseReason(), "Excess Flood", "Wrong reason")
+}
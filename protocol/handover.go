@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"time"
+)
+
+func (conn *IrcConnection) isDetached() bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return conn.detached
+}
+
+// NetConn is the socket the connection is served on.
+func (conn *IrcConnection) NetConn() net.Conn {
+	return conn.conn
+}
+
+// StopReading makes the reader of a connection about to be detached stop
+// without closing the socket. It returns a channel closed once the reader
+// has finished, which may take the lines it has read already being taken
+// off the incoming channel first.
+func (conn *IrcConnection) StopReading() <-chan bool {
+	conn.mutex.Lock()
+	conn.detached = true
+	conn.mutex.Unlock()
+
+	conn.conn.SetReadDeadline(time.Now())
+	return conn.readerDone
+}
+
+// Detach stops serving a registered connection without closing its socket
+// so that it can be handed over to a new process. It returns the input
+// read but not yet processed and the output not yet written, or false if
+// the connection has been closed already.
+func (conn *IrcConnection) Detach() ([]byte, []string, bool) {
+	conn.mutex.Lock()
+	if conn.closed {
+		conn.mutex.Unlock()
+		return nil, nil, false
+	}
+
+	conn.detached = true
+	conn.closed = true
+	close(conn.quit)
+	conn.mutex.Unlock()
+
+	conn.conn.SetReadDeadline(time.Now())
+	<-conn.receiverDone
+	<-conn.readerDone
+	<-conn.writerDone
+	conn.conn.SetReadDeadline(time.Time{})
+
+	input := []byte{}
+	buffered, _ := conn.reader.Peek(conn.reader.Buffered())
+
+	conn.mutex.Lock()
+	for _, line := range conn.recvq {
+		input = append(input, line...)
+	}
+	input = append(append(input, conn.partialLine...), buffered...)
+	conn.recvq = nil
+	conn.recvqBytes = 0
+
+	output := conn.sendq
+	conn.sendq = nil
+	conn.sendqBytes = 0
+	conn.mutex.Unlock()
+
+	return input, output, true
+}
+
+// ResumeIrcConnection takes over a connection detached by a previous
+// process. The input is read before anything else from the socket and the
+// output is queued for writing.
+func ResumeIrcConnection(conn net.Conn, class *config.Class,
+	incoming chan ClientAction, input []byte, output []string) *IrcConnection {
+	c := NewIrcConnection(conn, class, incoming)
+	c.reader = bufio.NewReaderSize(io.MultiReader(bytes.NewReader(input),
+		conn), class.RecvQ)
+
+	for _, line := range output {
+		c.Send(line)
+	}
+
+	return c
+}
+
+// Resume serves a resumed connection, which has been registered already.
+func (conn *IrcConnection) Resume() {
+	go conn.keepaliveRoutine()
+	go conn.receiverRoutine()
+	conn.serveRegistered()
+}
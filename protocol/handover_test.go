@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"net"
+	"testing"
+)
+
+func TestDetach(t *testing.T) {
+	class := &config.Class{SendQ: 1 << 20, RecvQ: 512, FloodBurst: 10,
+		FloodRate: 1, ExcessFlood: 20}
+	socket, client := net.Pipe()
+	defer client.Close()
+
+	incoming := make(chan ClientAction, 10)
+	conn := NewIrcConnection(socket, class, incoming)
+	go conn.Resume()
+
+	go client.Write([]byte("PING :a\r\nPRIVMSG #x :h"))
+	action := <-incoming
+	assert.Equal(t, action.Message, PingMessage{"a"}, "Line not read")
+
+	input, output, ok := conn.Detach()
+	assert.True(t, ok, "Open connection not detached")
+	assert.Equal(t, string(input), "PRIVMSG #x :h", "Partial line lost")
+	assert.Equal(t, len(output), 0, "Output made up")
+
+	resumed := ResumeIrcConnection(socket, class, incoming, input, nil)
+	go resumed.Resume()
+	defer resumed.Close()
+
+	go client.Write([]byte("i\r\n"))
+	action = <-incoming
+	assert.Equal(t, action.Message, PrivateMessage{"#x", "hi"},
+		"Partial line not continued")
+}
+
+func TestDetachClosed(t *testing.T) {
+	class := &config.Class{SendQ: 1 << 20, RecvQ: 512}
+	socket, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewIrcConnection(socket, class, make(chan ClientAction, 10))
+	go conn.Resume()
+	conn.Close()
+
+	_, _, ok := conn.Detach()
+	assert.False(t, ok, "Closed connection detached")
+}
@@ -6,10 +6,13 @@ import (
 
 type handshake struct {
 	hostname     string
+	ident        string
+	identChecked bool
 	nickMessage  NickMessage
 	userMessage  UserMessage
 	nickReceived bool
 	userReceived bool
+	negotiating  bool
 	messagesRead int
 	nickRetries  int
 	newClients   chan ConnectionInitiationAction
@@ -19,14 +22,13 @@ type handshake struct {
 }
 
 func newHandshake(conn *IrcConnection,
-	newClients chan ConnectionInitiationAction, hostname string) *handshake {
+	newClients chan ConnectionInitiationAction) *handshake {
 	hs := new(handshake)
 	hs.nickReceived = false
 	hs.userReceived = false
 	hs.messagesRead = 0
 	hs.nickRetries = 0
 	hs.responseChan = make(chan ConnectionInitiationActionResponse, 1)
-	hs.hostname = hostname
 	hs.newClients = newClients
 	hs.conn = conn
 
@@ -34,18 +36,38 @@ func newHandshake(conn *IrcConnection,
 }
 
 func (hs *handshake) readMessages() bool {
-	for (!hs.nickReceived || !hs.userReceived) && hs.messagesRead < 4 {
+	// capability negotiation is bounded by the registration timeout rather
+	// than by the number of lines, and nobody registers in the middle of it
+	for !hs.nickReceived || !hs.userReceived || hs.negotiating {
+		if !hs.negotiating && hs.messagesRead >= 4 {
+			break
+		}
+
 		select {
 		case <-hs.conn.quit:
 			return false
 		default:
 		}
 
-		message, err := hs.conn.readMessage()
+		action, err := hs.conn.readMessage()
 		if err != nil {
 			log.Printf("%v read failed: %v", hs.conn, err)
 			return false
 		}
+		message := action.Message
+
+		// clients may PING while registering, those are answered right
+		// away and are not counted against the message limit
+		if message.GetType() == PING {
+			hs.conn.write(PongReply(message.(PingMessage).Token))
+			continue
+		}
+
+		// capability negotiation holds registration back until CAP END
+		if message.GetType() == CAP {
+			hs.negotiate(message.(CapMessage))
+			continue
+		}
 		hs.messagesRead += 1
 
 		if message.GetType() == USER {
@@ -60,9 +82,50 @@ func (hs *handshake) readMessages() bool {
 	return hs.nickReceived && hs.userReceived
 }
 
+func (hs *handshake) negotiate(message CapMessage) {
+	switch message.Subcommand {
+	case "LS", "REQ":
+		hs.negotiating = true
+	case "END":
+		hs.negotiating = false
+	}
+
+	nick := "*"
+	if hs.nickReceived {
+		nick = hs.nickMessage.Nick
+	}
+
+	if reply := hs.conn.CapReply(message, nick); reply != "" {
+		hs.conn.write(reply)
+	}
+}
+
+// username is the verified ident username or the one the client claimed.
+// If ident is looked up but fails, the claimed one is marked as unverified
+// with a '~' prefix.
+func (hs *handshake) username() string {
+	if hs.ident != "" {
+		return hs.ident
+	}
+
+	username := sanitizeUsername(hs.userMessage.Username)
+	if !hs.conn.identEnabled() {
+		return username
+	}
+
+	if len(username) >= userLen() {
+		username = username[:userLen()-1]
+	}
+
+	return "~" + username
+}
+
 func (hs *handshake) register() bool {
 	if hs.nickReceived && hs.userReceived {
-		hs.newClients <- ConnectionInitiationAction{hs.userMessage,
+		userMessage := hs.userMessage
+		userMessage.Username = hs.username()
+
+		hs.newClients <- ConnectionInitiationAction{userMessage,
 			hs.nickMessage, hs.hostname, hs.conn, hs.responseChan}
 		response := <-hs.responseChan
 
@@ -81,7 +144,9 @@ func (hs *handshake) register() bool {
 
 func (conn *IrcConnection) handshake(
 	newClients chan ConnectionInitiationAction) bool {
-	hs := newHandshake(conn, newClients, conn.getHostname())
+	hostname := conn.lookupHostname()
+	ident := conn.lookupIdent()
+	hs := newHandshake(conn, newClients)
 
 	for hs.nickRetries < 3 {
 		ok := hs.readMessages()
@@ -89,6 +154,16 @@ func (conn *IrcConnection) handshake(
 			return false
 		}
 
+		if hs.hostname == "" {
+			hs.hostname = <-hostname
+			conn.CheckFloodExemptHost(hs.hostname)
+		}
+
+		if !hs.identChecked {
+			hs.ident = <-ident
+			hs.identChecked = true
+		}
+
 		ok = hs.register()
 		if !ok {
 			hs.nickRetries += 1
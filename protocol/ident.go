@@ -0,0 +1,138 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// queryIdent asks the RFC 1413 server at addr who owns the connection
+// between remotePort on its host and localPort on ours.
+func queryIdent(addr string, remotePort, localPort int,
+	timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(deadline)
+
+	_, err = fmt.Fprintf(conn, "%d , %d\r\n", remotePort, localPort)
+	if err != nil {
+		return "", err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	// 6193, 23 : USERID : UNIX : stjohns
+	fields := strings.SplitN(strings.TrimRight(line, "\r\n"), ":", 4)
+	if len(fields) < 3 {
+		return "", errors.New("malformed ident response")
+	}
+
+	if strings.TrimSpace(fields[1]) != "USERID" || len(fields) != 4 {
+		return "", fmt.Errorf("ident error: %s", strings.TrimSpace(fields[2]))
+	}
+
+	username := sanitizeUsername(strings.TrimSpace(fields[3]))
+	if username == "" {
+		return "", errors.New("unusable ident username")
+	}
+
+	return username, nil
+}
+
+// userLen is the configured UserLen, which is at least long enough for the
+// '~' and one character.
+func userLen() int {
+	if config.Config.UserLen < 2 {
+		return 2
+	}
+
+	return config.Config.UserLen
+}
+
+func sanitizeUsername(username string) string {
+	clean := []byte{}
+	for i := 0; i < len(username) && len(clean) < userLen(); i++ {
+		c := username[i]
+		if c <= ' ' || c == '@' || c == '!' || c == ':' || c >= 0x7f {
+			continue
+		}
+
+		clean = append(clean, c)
+	}
+
+	return string(clean)
+}
+
+func addrPort(addr net.Addr) int {
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return 0
+	}
+
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// identEnabled tells whether the client's ident is looked up. That takes
+// the client being at the other end of the socket, so clients behind a
+// proxy or a WebSocket gateway are not asked.
+func (conn *IrcConnection) identEnabled() bool {
+	if !config.Config.Ident {
+		return false
+	}
+
+	socket := conn.conn
+	if c, ok := socket.(*tls.Conn); ok {
+		socket = c.NetConn()
+	}
+
+	_, ok := socket.(*net.TCPConn)
+	return ok
+}
+
+// lookupIdent queries the client's ident server in the background. The
+// channel yields the verified username or "" when there is none.
+func (conn *IrcConnection) lookupIdent() chan string {
+	result := make(chan string, 1)
+
+	ip := conn.RemoteIP()
+	if !conn.identEnabled() || ip == nil {
+		result <- ""
+		return result
+	}
+
+	conn.authNotice("*** Checking Ident")
+
+	go func() {
+		addr := net.JoinHostPort(ip.String(),
+			strconv.Itoa(config.Config.IdentPort))
+		username, err := queryIdent(addr, addrPort(conn.conn.RemoteAddr()),
+			addrPort(conn.conn.LocalAddr()), config.Config.IdentTimeout)
+		if err != nil {
+			conn.authNotice("*** No Ident response")
+			result <- ""
+			return
+		}
+
+		conn.authNotice("*** Got Ident response")
+		result <- username
+	}()
+
+	return result
+}
@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveIdent answers a single ident query with the given reply.
+func serveIdent(t *testing.T, reply string) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "Listening failed")
+
+	queries := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		query, _ := bufio.NewReader(conn).ReadString('\n')
+		queries <- query
+		fmt.Fprintf(conn, "%s\r\n", reply)
+	}()
+
+	return listener.Addr().String(), queries
+}
+
+func TestQueryIdent(t *testing.T) {
+	addr, queries := serveIdent(t, "6193, 6667 : USERID : UNIX : juke")
+	username, err := queryIdent(addr, 6193, 6667, time.Second)
+	assert.NoError(t, err, "Ident query failed")
+	assert.Equal(t, username, "juke", "Ident username parsed incorrectly")
+	assert.Equal(t, <-queries, "6193 , 6667\r\n", "Ident query malformed")
+
+	addr, _ = serveIdent(t, "6193, 6667 : ERROR : NO-USER")
+	_, err = queryIdent(addr, 6193, 6667, time.Second)
+	assert.Error(t, err, "Ident error accepted")
+
+	addr, _ = serveIdent(t, "6193, 6667 : USERID : UNIX : a:very@long!name")
+	username, _ = queryIdent(addr, 6193, 6667, time.Second)
+	assert.Equal(t, username, "averylongn", "Ident username not sanitized")
+}
+
+func TestUsername(t *testing.T) {
+	socket, client := net.Pipe()
+	defer client.Close()
+
+	defer func(ident bool, userLen int) {
+		config.Config.Ident = ident
+		config.Config.UserLen = userLen
+	}(config.Config.Ident, config.Config.UserLen)
+	config.Config.UserLen = 10
+
+	hs := newHandshake(&IrcConnection{conn: socket}, nil)
+	hs.userMessage.Username = "averylongname"
+
+	config.Config.Ident = false
+	assert.Equal(t, hs.username(), "averylongn",
+		"Username marked without ident")
+
+	// the pipe is no TCP connection, like one from a proxy
+	config.Config.Ident = true
+	assert.Equal(t, hs.username(), "averylongn", "Proxied client marked")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "Listening failed")
+	defer listener.Close()
+
+	direct, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err, "Connecting failed")
+	defer direct.Close()
+
+	hs.conn.conn = direct
+	assert.Equal(t, hs.username(), "~averylong",
+		"Unverified username not marked")
+
+	config.Config.UserLen = 0
+	assert.Equal(t, hs.username(), "~a", "Username not clamped")
+
+	hs.ident = "juke"
+	assert.Equal(t, hs.username(), "juke", "Ident username not used")
+}
@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+func newPingToken() string {
+	return strconv.FormatInt(rand.Int63(), 36)
+}
+
+// Ping sends a PING with a fresh token. The token is remembered so that
+// only the matching PONG counts as an answer.
+func (conn *IrcConnection) Ping() {
+	token := newPingToken()
+
+	conn.mutex.Lock()
+	conn.pingToken = token
+	conn.pingSent = time.Now()
+	conn.mutex.Unlock()
+
+	conn.SendMessage(PingMessage{token})
+}
+
+// Pong validates a PONG token against the outstanding PING and returns the
+// measured round trip time.
+func (conn *IrcConnection) Pong(token string) (time.Duration, bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.pingToken == "" || conn.pingToken != token {
+		return 0, false
+	}
+
+	conn.pingToken = ""
+	conn.lag = time.Since(conn.pingSent)
+
+	return conn.lag, true
+}
+
+// PongReply answers a client PING with the server as both prefix and
+// origin, i.e. ":server PONG server :token".
+func PongReply(token string) string {
+	id := config.Config.ServerID
+	return GetSerializedMessageFrom(id, PongMessage{id, token})
+}
+
+func (conn *IrcConnection) keepaliveRoutine() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			conn.checkKeepalive(now)
+		case <-conn.quit:
+			return
+		}
+	}
+}
+
+// checkKeepalive pings idle clients and drops those that do not answer.
+// Clients that have not registered are not pinged, they get
+// RegistrationTimeout to register.
+func (conn *IrcConnection) checkKeepalive(now time.Time) {
+	conn.mutex.Lock()
+	registered := conn.registered
+	connected := conn.stats.Connected
+	idle := now.Sub(conn.lastActivity)
+	waiting := conn.pingToken != ""
+	waited := now.Sub(conn.pingSent)
+	conn.mutex.Unlock()
+
+	if !registered {
+		if now.Sub(connected) > config.Config.RegistrationTimeout {
+			log.Printf("%v did not register in time", conn.conn.RemoteAddr())
+			conn.Quit("Registration timed out", now.Add(time.Second))
+		}
+		return
+	}
+
+	if waiting && waited > config.Config.PingTimeout {
+		conn.Disconnect(fmt.Sprintf("Ping timeout: %d seconds",
+			int(idle/time.Second)))
+		return
+	}
+
+	if !waiting && idle > conn.class.GetPingFrequency() {
+		conn.Ping()
+	}
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newKeepaliveTestConnection(registered bool) (*IrcConnection,
+	*bufio.Reader, net.Conn) {
+	class := &config.Class{SendQ: 1 << 20, RecvQ: 512,
+		PingFrequency: time.Minute}
+	socket, client := net.Pipe()
+
+	conn := NewIrcConnection(socket, class, make(chan ClientAction, 10))
+	if registered {
+		conn.registered = true
+		go conn.writerRoutine()
+	}
+
+	return conn, bufio.NewReader(client), client
+}
+
+func readTestLine(reader *bufio.Reader) string {
+	line, _ := reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+func TestPong(t *testing.T) {
+	conn := newTestConnection(0)
+	_, ok := conn.Pong("a")
+	assert.False(t, ok, "PONG accepted without a PING")
+
+	conn.Ping()
+	assert.Equal(t, conn.sendq, []string{"PING :" + conn.pingToken},
+		"PING not sent")
+	conn.pingSent = time.Now().Add(-time.Second)
+
+	_, ok = conn.Pong("wrong")
+	assert.False(t, ok, "PONG with a wrong token accepted")
+
+	lag, ok := conn.Pong(strings.TrimPrefix(conn.sendq[0], "PING :"))
+	assert.True(t, ok, "PONG with the token not accepted")
+	assert.True(t, lag >= time.Second, "Lag not measured")
+	assert.Equal(t, conn.Stats().Lag, lag, "Lag not kept")
+
+	_, ok = conn.Pong(strings.TrimPrefix(conn.sendq[0], "PING :"))
+	assert.False(t, ok, "PONG accepted twice")
+}
+
+func TestPingFrequency(t *testing.T) {
+	conn, reader, client := newKeepaliveTestConnection(true)
+	defer client.Close()
+	defer conn.Close()
+
+	now := time.Now()
+	conn.lastActivity = now
+	conn.checkKeepalive(now.Add(30 * time.Second))
+	assert.Equal(t, conn.pingToken, "", "Active client pinged")
+
+	conn.checkKeepalive(now.Add(61 * time.Second))
+	assert.Equal(t, readTestLine(reader), "PING :"+conn.pingToken,
+		"Idle client not pinged")
+}
+
+func TestPingTimeout(t *testing.T) {
+	defer func(timeout time.Duration) {
+		config.Config.PingTimeout = timeout
+	}(config.Config.PingTimeout)
+	config.Config.PingTimeout = 30 * time.Second
+
+	conn, reader, client := newKeepaliveTestConnection(true)
+	defer client.Close()
+	defer conn.Close()
+
+	now := time.Now()
+	conn.lastActivity = now.Add(-90 * time.Second)
+	conn.pingToken = "a"
+	conn.pingSent = now.Add(-10 * time.Second)
+	conn.checkKeepalive(now)
+	assert.Equal(t, conn.CloseReason(), "", "Client dropped while waiting")
+
+	conn.pingSent = now.Add(-31 * time.Second)
+	conn.checkKeepalive(now)
+	assert.Equal(t, readTestLine(reader),
+		"ERROR :Closing Link: (Ping timeout: 90 seconds)",
+		"Client not told about the timeout")
+	assert.Equal(t, (<-conn.incoming).Message, nil, "Client not dropped")
+}
+
+func TestRegistrationTimeout(t *testing.T) {
+	defer func(timeout time.Duration) {
+		config.Config.RegistrationTimeout = timeout
+	}(config.Config.RegistrationTimeout)
+	config.Config.RegistrationTimeout = 30 * time.Second
+
+	conn, reader, client := newKeepaliveTestConnection(false)
+	defer client.Close()
+
+	now := time.Now()
+	conn.stats.Connected = now
+	conn.lastActivity = now.Add(-time.Hour)
+	conn.checkKeepalive(now.Add(10 * time.Second))
+	assert.Equal(t, conn.pingToken, "", "Unregistered client pinged")
+
+	go conn.checkKeepalive(now.Add(31 * time.Second))
+	assert.Equal(t, readTestLine(reader), "ERROR :Registration timed out",
+		"Client not told about the timeout")
+	<-conn.Closed()
+}
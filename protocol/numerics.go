@@ -0,0 +1,209 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+
+	"fmt"
+	"strings"
+)
+
+// Numeric describes a numeric reply: its code, its name in the specs, the
+// middle parameters it takes after the recipient and its default text.
+type Numeric struct {
+	Code   int
+	Name   string
+	Params []string
+	Text   string
+}
+
+// The numerics sent by the server.
+var (
+	RPL_ISUPPORT = Numeric{5, "RPL_ISUPPORT",
+		[]string{"tokens"}, "are supported by this server"}
+	RPL_STATSLINKINFO = Numeric{211, "RPL_STATSLINKINFO",
+		[]string{"linkname", "sendq", "sent messages", "sent Kbytes",
+			"received messages", "received Kbytes", "lag ms"}, ""}
+	RPL_STATSCOMMANDS = Numeric{212, "RPL_STATSCOMMANDS",
+		[]string{"command", "count", "byte count", "remote count"}, ""}
+	RPL_ENDOFSTATS = Numeric{219, "RPL_ENDOFSTATS",
+		[]string{"query"}, "End of /STATS report"}
+	RPL_UMODEIS = Numeric{221, "RPL_UMODEIS",
+		[]string{"modes"}, ""}
+	RPL_STATSUPTIME = Numeric{242, "RPL_STATSUPTIME",
+		nil, ""}
+	RPL_STATSOLINE = Numeric{243, "RPL_STATSOLINE",
+		[]string{"O", "hostmask", "*", "name"}, ""}
+	RPL_LUSERCLIENT = Numeric{251, "RPL_LUSERCLIENT",
+		nil, ""}
+	RPL_LUSEROP = Numeric{252, "RPL_LUSEROP",
+		[]string{"ops"}, "operator(s) online"}
+	RPL_LUSERUNKNOWN = Numeric{253, "RPL_LUSERUNKNOWN",
+		[]string{"connections"}, "unknown connection(s)"}
+	RPL_LUSERCHANNELS = Numeric{254, "RPL_LUSERCHANNELS",
+		[]string{"channels"}, "channels formed"}
+	RPL_LUSERME = Numeric{255, "RPL_LUSERME",
+		nil, ""}
+	RPL_ADMINME = Numeric{256, "RPL_ADMINME",
+		[]string{"server"}, "Administrative info"}
+	RPL_ADMINLOC1 = Numeric{257, "RPL_ADMINLOC1",
+		[]string{":location"}, ""}
+	RPL_ADMINLOC2 = Numeric{258, "RPL_ADMINLOC2",
+		[]string{":location"}, ""}
+	RPL_ADMINEMAIL = Numeric{259, "RPL_ADMINEMAIL",
+		[]string{":email"}, ""}
+	RPL_LOCALUSERS = Numeric{265, "RPL_LOCALUSERS",
+		[]string{"users", "max"}, ""}
+	RPL_GLOBALUSERS = Numeric{266, "RPL_GLOBALUSERS",
+		[]string{"users", "max"}, ""}
+	RPL_AWAY = Numeric{301, "RPL_AWAY",
+		[]string{"nick", ":message"}, ""}
+	RPL_USERHOST = Numeric{302, "RPL_USERHOST",
+		[]string{":replies"}, ""}
+	RPL_ISON = Numeric{303, "RPL_ISON",
+		[]string{":nicks"}, ""}
+	RPL_UNAWAY = Numeric{305, "RPL_UNAWAY",
+		nil, "You are no longer marked as being away"}
+	RPL_NOWAWAY = Numeric{306, "RPL_NOWAWAY",
+		nil, "You have been marked as being away"}
+	RPL_CHANNELMODEIS = Numeric{324, "RPL_CHANNELMODEIS",
+		[]string{"channel", "modes"}, ""}
+	RPL_VERSION = Numeric{351, "RPL_VERSION",
+		[]string{"version", "server"}, ""}
+	RPL_NAMREPLY = Numeric{353, "RPL_NAMREPLY",
+		[]string{"symbol", "channel", ":nicks"}, ""}
+	RPL_ENDOFNAMES = Numeric{366, "RPL_ENDOFNAMES",
+		[]string{"channel"}, "End of /NAMES list"}
+	RPL_INFO = Numeric{371, "RPL_INFO",
+		[]string{":info"}, ""}
+	RPL_MOTD = Numeric{372, "RPL_MOTD",
+		nil, "- "}
+	RPL_ENDOFINFO = Numeric{374, "RPL_ENDOFINFO",
+		nil, "End of INFO list"}
+	RPL_MOTDSTART = Numeric{375, "RPL_MOTDSTART",
+		nil, "- Message of the day - "}
+	RPL_ENDOFMOTD = Numeric{376, "RPL_ENDOFMOTD",
+		nil, "End of /MOTD command."}
+	RPL_YOUREOPER = Numeric{381, "RPL_YOUREOPER",
+		nil, "You are now an IRC operator"}
+	RPL_REHASHING = Numeric{382, "RPL_REHASHING",
+		[]string{"config file"}, "Rehashing"}
+	RPL_TIME = Numeric{391, "RPL_TIME",
+		[]string{"server"}, ""}
+	RPL_MONONLINE = Numeric{730, "RPL_MONONLINE",
+		[]string{":targets"}, ""}
+	RPL_MONOFFLINE = Numeric{731, "RPL_MONOFFLINE",
+		[]string{":targets"}, ""}
+	RPL_MONLIST = Numeric{732, "RPL_MONLIST",
+		[]string{":targets"}, ""}
+	RPL_ENDOFMONLIST = Numeric{733, "RPL_ENDOFMONLIST",
+		nil, "End of MONITOR list"}
+
+	ERR_NOSUCHNICK = Numeric{401, "ERR_NOSUCHNICK",
+		[]string{"nick"}, "No such nick/channel"}
+	ERR_NOSUCHCHANNEL = Numeric{403, "ERR_NOSUCHCHANNEL",
+		[]string{"channel"}, "No such channel"}
+	ERR_CANNOTSENDTOCHAN = Numeric{404, "ERR_CANNOTSENDTOCHAN",
+		[]string{"channel"}, "Cannot send to channel"}
+	ERR_NOORIGIN = Numeric{409, "ERR_NOORIGIN",
+		nil, "No origin specified"}
+	ERR_INVALIDCAPCMD = Numeric{410, "ERR_INVALIDCAPCMD",
+		[]string{"subcommand"}, "Invalid CAP command"}
+	ERR_NORECIPIENT = Numeric{411, "ERR_NORECIPIENT",
+		nil, "No recipient given"}
+	ERR_NOTEXTTOSEND = Numeric{412, "ERR_NOTEXTTOSEND",
+		nil, "No text to send"}
+	ERR_UNKNOWNCOMMAND = Numeric{421, "ERR_UNKNOWNCOMMAND",
+		[]string{"command"}, "Unknown command"}
+	ERR_NOMOTD = Numeric{422, "ERR_NOMOTD",
+		nil, "MOTD File is missing"}
+	ERR_NOADMININFO = Numeric{423, "ERR_NOADMININFO",
+		[]string{"server"}, "No administrative info available"}
+	ERR_NONICKNAMEGIVEN = Numeric{431, "ERR_NONICKNAMEGIVEN",
+		nil, "No nickname given"}
+	ERR_NICKNAMEINUSE = Numeric{433, "ERR_NICKNAMEINUSE",
+		[]string{"nick"}, "Nickname is already in use"}
+	ERR_NOTONCHANNEL = Numeric{442, "ERR_NOTONCHANNEL",
+		[]string{"channel"}, "You're not on that channel"}
+	ERR_NEEDMOREPARAMS = Numeric{461, "ERR_NEEDMOREPARAMS",
+		[]string{"command"}, "Not enough parameters"}
+	ERR_PASSWDMISMATCH = Numeric{464, "ERR_PASSWDMISMATCH",
+		nil, "Password incorrect"}
+	ERR_UNKNOWNMODE = Numeric{472, "ERR_UNKNOWNMODE",
+		[]string{"modechar"}, "is unknown mode char to me"}
+	ERR_NOPRIVILEGES = Numeric{481, "ERR_NOPRIVILEGES",
+		nil, "Permission Denied- You're not an IRC operator"}
+	ERR_CHANOPRIVSNEEDED = Numeric{482, "ERR_CHANOPRIVSNEEDED",
+		[]string{"channel"}, "You're not channel operator"}
+	ERR_NOOPERHOST = Numeric{491, "ERR_NOOPERHOST",
+		nil, "No O-lines for your host"}
+	ERR_USERSDONTMATCH = Numeric{502, "ERR_USERSDONTMATCH",
+		nil, "Cant change mode for other users"}
+	ERR_MONLISTFULL = Numeric{734, "ERR_MONLISTFULL",
+		[]string{"limit", "targets"}, "Monitor list is full."}
+)
+
+// NumericMessage is a numeric reply to a client, addressed to its nick or
+// '*' before it has one. Missing parameters are sent as '*' and the text
+// of the numeric is used unless another one is given. Numerics without
+// any text end with their last parameter, unless it is marked as free text
+// with a ':' in the catalogue, in which case it is sent as the trailing
+// parameter even when empty.
+type NumericMessage struct {
+	Numeric Numeric
+	Target  string
+	Params  []string
+	Text    string
+}
+
+func NewNumeric(numeric Numeric, target string,
+	params ...string) NumericMessage {
+	return NumericMessage{numeric, target, params, ""}
+}
+
+func (m NumericMessage) GetType() MessageType {
+	return NUMERIC
+}
+
+// hasFreeText tells whether the last parameter of the numeric is free text.
+func (n Numeric) hasFreeText() bool {
+	return len(n.Params) > 0 &&
+		strings.HasPrefix(n.Params[len(n.Params)-1], ":")
+}
+
+func (m NumericMessage) Serialize() string {
+	params := append([]string{m.Target}, m.Params...)
+	middle := len(m.Numeric.Params)
+
+	text := m.Text
+	if text == "" {
+		text = m.Numeric.Text
+	}
+	trailing := text != ""
+
+	if m.Numeric.hasFreeText() {
+		for len(params) <= middle {
+			params = append(params, "")
+		}
+
+		text, trailing = params[middle], true
+		params = params[:middle]
+		middle -= 1
+	}
+
+	for len(params) <= middle {
+		params = append(params, "*")
+	}
+
+	line := fmt.Sprintf(":%s %03d %s", config.Config.ServerID,
+		m.Numeric.Code, strings.Join(params, " "))
+	if !trailing {
+		return line
+	}
+
+	return fmt.Sprintf("%s :%s", line, text)
+}
+
+// Error sends an error numeric to the nick about the parameters.
+func (r *Response) Error(nick string, numeric Numeric, params ...string) {
+	r.SendMessage(NewNumeric(numeric, nick, params...))
+}
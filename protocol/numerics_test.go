@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestNumericMessage(t *testing.T) {
+	config.Config.ServerID = "irc.example"
+
+	m := NewNumeric(ERR_NICKNAMEINUSE, "*", "alice")
+	assert.Equal(t, m.Serialize(),
+		":irc.example 433 * alice :Nickname is already in use",
+		"433 before registration")
+
+	m = NewNumeric(ERR_NICKNAMEINUSE, "bob", "alice")
+	assert.Equal(t, m.Serialize(),
+		":irc.example 433 bob alice :Nickname is already in use",
+		"433 after registration")
+
+	m = NewNumeric(ERR_MONLISTFULL, "bob", "100", "alice,carol")
+	assert.Equal(t, m.Serialize(),
+		":irc.example 734 bob 100 alice,carol :Monitor list is full.",
+		"Multiple parameters")
+
+	m = NewNumeric(ERR_NEEDMOREPARAMS, "bob")
+	assert.Equal(t, m.Serialize(),
+		":irc.example 461 bob * :Not enough parameters",
+		"Missing parameter not filled in")
+
+	m = NewNumeric(ERR_NOORIGIN, "bob")
+	m.Text = "No token given"
+	assert.Equal(t, m.Serialize(), ":irc.example 409 bob :No token given",
+		"Text not overridden")
+
+	m = NewNumeric(RPL_STATSOLINE, "bob", "O", "*@*", "*", "admin")
+	assert.Equal(t, m.Serialize(), ":irc.example 243 bob O *@* * admin",
+		"Numeric without text")
+
+	m = NewNumeric(RPL_ISON, "bob")
+	assert.Equal(t, m.Serialize(), ":irc.example 303 bob :",
+		"Empty free text not sent")
+
+	m = NewNumeric(RPL_NAMREPLY, "bob", "@", "#x", "alice bob")
+	assert.Equal(t, m.Serialize(), ":irc.example 353 bob @ #x :alice bob",
+		"Free text not trailing")
+
+	assert.Equal(t, m.GetType(), NUMERIC, "Wrong type")
+}
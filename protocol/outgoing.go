@@ -0,0 +1,124 @@
+package protocol
+
+// serializationCapabilities are the capabilities that change how a message
+// is serialized.
+const serializationCapabilities = CapMessageTags | CapServerTime | CapBatch |
+	CapLabeledResponse | CapExtendedJoin | CapAccountNotify | CapChgHost |
+	CapSetName
+
+// capabilityMessage is a message that looks different to clients with some
+// capabilities.
+type capabilityMessage interface {
+	SerializeFor(caps Capability) string
+}
+
+// Outgoing is a message on its way to a number of connections. It is
+// serialized once for each set of capabilities among them rather than
+// once per recipient, by the one goroutine sending it.
+type Outgoing struct {
+	Source   string
+	Message  IrcMessage
+	Metadata Metadata
+
+	// Batch is the batch the message is sent in, if any. Label is set on
+	// the reply to a labeled command.
+	Batch *Batch
+	Label string
+
+	lines map[Capability]string
+}
+
+func NewOutgoing(source string, message IrcMessage,
+	metadata Metadata) *Outgoing {
+	return &Outgoing{source, message, metadata, nil, "",
+		map[Capability]string{}}
+}
+
+// within returns a copy of the message with the label and, unless it is
+// already in one, put in the batch.
+func (o *Outgoing) within(label string, batch *Batch) *Outgoing {
+	c := NewOutgoing(o.Source, o.Message, o.Metadata)
+	c.Batch = o.Batch
+	if c.Batch == nil {
+		c.Batch = batch
+	}
+	c.Label = label
+
+	return c
+}
+
+func (o *Outgoing) tags(caps Capability) Tags {
+	tags := Tags{}
+	if caps.Has(CapLabeledResponse) && o.Label != "" {
+		tags = append(tags, Tag{"label", o.Label})
+	}
+
+	if caps.Has(CapBatch) && o.Batch != nil {
+		tags = append(tags, Tag{"batch", o.Batch.Ref})
+	}
+
+	if caps.Has(CapServerTime) && !o.Metadata.Time.IsZero() {
+		tags = append(tags, Tag{"time", FormatTime(o.Metadata.Time)})
+	}
+
+	if caps.Has(CapMessageTags) {
+		if o.Metadata.ID != "" {
+			tags = append(tags, Tag{"msgid", o.Metadata.ID})
+		}
+		tags = append(tags, o.Metadata.Tags...)
+	}
+
+	return tags
+}
+
+// Serialize returns the line for a connection with the given capabilities,
+// or nothing if the message cannot be sent to it: TAGMSG, BATCH and the
+// notifications of user changes only go to connections that negotiated
+// the capability they belong to.
+func (o *Outgoing) Serialize(caps Capability) string {
+	caps &= serializationCapabilities
+
+	if line, ok := o.lines[caps]; ok {
+		return line
+	}
+
+	line := ""
+	if o.sendable(caps) {
+		line = o.tags(caps).Serialize()
+		if o.Source != "" {
+			line += ":" + o.Source + " "
+		}
+		if m, ok := o.Message.(capabilityMessage); ok {
+			line += m.SerializeFor(caps)
+		} else {
+			line += o.Message.Serialize()
+		}
+	}
+
+	o.lines[caps] = line
+	return line
+}
+
+func (o *Outgoing) sendable(caps Capability) bool {
+	switch o.Message.GetType() {
+	case TAGMSG:
+		return caps.Has(CapMessageTags)
+	case BATCH:
+		return caps.Has(CapBatch)
+	case ACCOUNT:
+		return caps.Has(CapAccountNotify)
+	case CHGHOST:
+		return caps.Has(CapChgHost)
+	case SETNAME:
+		return caps.Has(CapSetName)
+	default:
+		return true
+	}
+}
+
+func (conn *IrcConnection) SendOutgoing(o *Outgoing) {
+	line := o.Serialize(conn.Capabilities())
+	if line != "" {
+		conn.Send(line)
+	}
+}
@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"fmt"
+	"strings"
 )
 
 type MessageType int
@@ -18,6 +19,31 @@ const (
 	PING
 	PONG
 	NUMERIC
+	OPER
+	DIE
+	RESTART
+	MODE
+	NOTICE
+	CHATHISTORY
+	CAP
+	TAGMSG
+	BATCH
+	SETNAME
+	CHGHOST
+	ACCOUNT
+	MONITOR
+	ISON
+	USERHOST
+	AWAY
+	INVALID
+	MOTD
+	REHASH
+	LUSERS
+	VERSION
+	TIME
+	ADMIN
+	INFO
+	STATS
 
 	UNKNOWN
 )
@@ -47,7 +73,8 @@ func (m PingMessage) Serialize() string {
 
 /* -------------------------------------------------------------------------- */
 type PongMessage struct {
-	Token string
+	Server string
+	Token  string
 }
 
 func (m PongMessage) GetType() MessageType {
@@ -55,7 +82,11 @@ func (m PongMessage) GetType() MessageType {
 }
 
 func (m PongMessage) Serialize() string {
-	return fmt.Sprintf("PONG :%s", m.Token)
+	if m.Server == "" {
+		return fmt.Sprintf("PONG :%s", m.Token)
+	}
+
+	return fmt.Sprintf("PONG %s :%s", m.Server, m.Token)
 }
 
 /* -------------------------------------------------------------------------- */
@@ -71,6 +102,20 @@ func (m UnknownMessage) Serialize() string {
 	return m.Message
 }
 
+/* -------------------------------------------------------------------------- */
+// InvalidMessage is a known command given without the parameters it needs.
+type InvalidMessage struct {
+	Command string
+}
+
+func (m InvalidMessage) GetType() MessageType {
+	return INVALID
+}
+
+func (m InvalidMessage) Serialize() string {
+	return m.Command
+}
+
 /* -------------------------------------------------------------------------- */
 type NickMessage struct {
 	Nick string
@@ -118,8 +163,12 @@ func (m PrivateMessage) GetTarget() string {
 }
 
 /* -------------------------------------------------------------------------- */
+// JoinMessage carries the account and realname of the joining user for
+// clients with extended-join. The account is "*" for users not logged in.
 type JoinMessage struct {
-	Target string
+	Target   string
+	Account  string
+	Realname string
 }
 
 func (m JoinMessage) GetType() MessageType {
@@ -130,6 +179,14 @@ func (m JoinMessage) Serialize() string {
 	return fmt.Sprintf("JOIN :%s", m.Target)
 }
 
+func (m JoinMessage) SerializeFor(caps Capability) string {
+	if !caps.Has(CapExtendedJoin) || m.Account == "" {
+		return m.Serialize()
+	}
+
+	return fmt.Sprintf("JOIN %s %s :%s", m.Target, m.Account, m.Realname)
+}
+
 func (m JoinMessage) GetTarget() string {
 	return m.Target
 }
@@ -165,18 +222,370 @@ func (m QuitMessage) Serialize() string {
 }
 
 /* -------------------------------------------------------------------------- */
-type NumericMessage struct {
-	Source  string
-	Code    int
+type OperMessage struct {
+	Name     string
+	Password string
+}
+
+func (m OperMessage) GetType() MessageType {
+	return OPER
+}
+
+func (m OperMessage) Serialize() string {
+	return fmt.Sprintf("OPER %s %s", m.Name, m.Password)
+}
+
+/* -------------------------------------------------------------------------- */
+type DieMessage struct {
+}
+
+func (m DieMessage) GetType() MessageType {
+	return DIE
+}
+
+func (m DieMessage) Serialize() string {
+	return "DIE"
+}
+
+/* -------------------------------------------------------------------------- */
+type RestartMessage struct {
+}
+
+func (m RestartMessage) GetType() MessageType {
+	return RESTART
+}
+
+func (m RestartMessage) Serialize() string {
+	return "RESTART"
+}
+
+/* -------------------------------------------------------------------------- */
+type MotdMessage struct {
+	Target string
+}
+
+func (m MotdMessage) GetType() MessageType {
+	return MOTD
+}
+
+func (m MotdMessage) Serialize() string {
+	if m.Target == "" {
+		return "MOTD"
+	}
+
+	return fmt.Sprintf("MOTD %s", m.Target)
+}
+
+/* -------------------------------------------------------------------------- */
+type RehashMessage struct {
+}
+
+func (m RehashMessage) GetType() MessageType {
+	return REHASH
+}
+
+func (m RehashMessage) Serialize() string {
+	return "REHASH"
+}
+
+/* -------------------------------------------------------------------------- */
+type LusersMessage struct {
+}
+
+func (m LusersMessage) GetType() MessageType {
+	return LUSERS
+}
+
+func (m LusersMessage) Serialize() string {
+	return "LUSERS"
+}
+
+/* -------------------------------------------------------------------------- */
+type VersionMessage struct {
+}
+
+func (m VersionMessage) GetType() MessageType {
+	return VERSION
+}
+
+func (m VersionMessage) Serialize() string {
+	return "VERSION"
+}
+
+/* -------------------------------------------------------------------------- */
+type TimeMessage struct {
+}
+
+func (m TimeMessage) GetType() MessageType {
+	return TIME
+}
+
+func (m TimeMessage) Serialize() string {
+	return "TIME"
+}
+
+/* -------------------------------------------------------------------------- */
+type AdminMessage struct {
+}
+
+func (m AdminMessage) GetType() MessageType {
+	return ADMIN
+}
+
+func (m AdminMessage) Serialize() string {
+	return "ADMIN"
+}
+
+/* -------------------------------------------------------------------------- */
+type InfoMessage struct {
+}
+
+func (m InfoMessage) GetType() MessageType {
+	return INFO
+}
+
+func (m InfoMessage) Serialize() string {
+	return "INFO"
+}
+
+/* -------------------------------------------------------------------------- */
+type StatsMessage struct {
+	Query string
+}
+
+func (m StatsMessage) GetType() MessageType {
+	return STATS
+}
+
+func (m StatsMessage) Serialize() string {
+	return fmt.Sprintf("STATS %s", m.Query)
+}
+
+/* -------------------------------------------------------------------------- */
+type ModeMessage struct {
+	Target string
+	Modes  string
+}
+
+func (m ModeMessage) GetType() MessageType {
+	return MODE
+}
+
+func (m ModeMessage) Serialize() string {
+	if m.Modes == "" {
+		return fmt.Sprintf("MODE %s", m.Target)
+	}
+
+	return fmt.Sprintf("MODE %s %s", m.Target, m.Modes)
+}
+
+func (m ModeMessage) GetTarget() string {
+	return m.Target
+}
+
+/* -------------------------------------------------------------------------- */
+type NoticeMessage struct {
 	Target  string
 	Message string
 }
 
-func (m NumericMessage) GetType() MessageType {
-	return PRIVATE
+func (m NoticeMessage) GetType() MessageType {
+	return NOTICE
+}
+
+func (m NoticeMessage) Serialize() string {
+	return fmt.Sprintf("NOTICE %s :%s", m.Target, m.Message)
+}
+
+func (m NoticeMessage) GetTarget() string {
+	return m.Target
+}
+
+/* -------------------------------------------------------------------------- */
+type ChatHistoryMessage struct {
+	Subcommand string
+	Params     []string
+}
+
+func (m ChatHistoryMessage) GetType() MessageType {
+	return CHATHISTORY
+}
+
+func (m ChatHistoryMessage) Serialize() string {
+	return strings.Join(append([]string{"CHATHISTORY", m.Subcommand},
+		m.Params...), " ")
+}
+
+/* -------------------------------------------------------------------------- */
+type CapMessage struct {
+	Subcommand string
+	Params     string
+}
+
+func (m CapMessage) GetType() MessageType {
+	return CAP
+}
+
+func (m CapMessage) Serialize() string {
+	if m.Params == "" {
+		return fmt.Sprintf("CAP %s", m.Subcommand)
+	}
+
+	return fmt.Sprintf("CAP %s :%s", m.Subcommand, m.Params)
+}
+
+/* -------------------------------------------------------------------------- */
+type TagMessage struct {
+	Target string
+}
+
+func (m TagMessage) GetType() MessageType {
+	return TAGMSG
+}
+
+func (m TagMessage) Serialize() string {
+	return fmt.Sprintf("TAGMSG %s", m.Target)
+}
+
+func (m TagMessage) GetTarget() string {
+	return m.Target
+}
+
+/* -------------------------------------------------------------------------- */
+type BatchMessage struct {
+	Ref    string
+	Type   string
+	Params []string
+}
+
+func (m BatchMessage) GetType() MessageType {
+	return BATCH
 }
 
-func (m NumericMessage) Serialize() string {
-	return fmt.Sprintf(":%s %d * %s :%s", m.Source, m.Code, m.Target,
-		m.Message)
+func (m BatchMessage) Serialize() string {
+	if m.Type == "" {
+		return fmt.Sprintf("BATCH %s", m.Ref)
+	}
+
+	return strings.Join(append([]string{"BATCH", m.Ref, m.Type},
+		m.Params...), " ")
+}
+
+/* -------------------------------------------------------------------------- */
+type SetNameMessage struct {
+	Realname string
+}
+
+func (m SetNameMessage) GetType() MessageType {
+	return SETNAME
+}
+
+func (m SetNameMessage) Serialize() string {
+	return fmt.Sprintf("SETNAME :%s", m.Realname)
+}
+
+/* -------------------------------------------------------------------------- */
+// ChgHostMessage is the operator command changing the host of a user.
+type ChgHostMessage struct {
+	Nick     string
+	Hostname string
+}
+
+func (m ChgHostMessage) GetType() MessageType {
+	return CHGHOST
+}
+
+func (m ChgHostMessage) Serialize() string {
+	return fmt.Sprintf("CHGHOST %s %s", m.Nick, m.Hostname)
+}
+
+/* -------------------------------------------------------------------------- */
+// HostChangeMessage tells clients that a user's username or host changed.
+type HostChangeMessage struct {
+	Username string
+	Hostname string
+}
+
+func (m HostChangeMessage) GetType() MessageType {
+	return CHGHOST
+}
+
+func (m HostChangeMessage) Serialize() string {
+	return fmt.Sprintf("CHGHOST %s %s", m.Username, m.Hostname)
+}
+
+/* -------------------------------------------------------------------------- */
+type AccountMessage struct {
+	Account string
+}
+
+func (m AccountMessage) GetType() MessageType {
+	return ACCOUNT
+}
+
+func (m AccountMessage) Serialize() string {
+	return fmt.Sprintf("ACCOUNT %s", m.Account)
+}
+
+/* -------------------------------------------------------------------------- */
+type MonitorMessage struct {
+	Subcommand string
+	Targets    []string
+}
+
+func (m MonitorMessage) GetType() MessageType {
+	return MONITOR
+}
+
+func (m MonitorMessage) Serialize() string {
+	if len(m.Targets) == 0 {
+		return fmt.Sprintf("MONITOR %s", m.Subcommand)
+	}
+
+	return fmt.Sprintf("MONITOR %s %s", m.Subcommand,
+		strings.Join(m.Targets, ","))
+}
+
+/* -------------------------------------------------------------------------- */
+type IsonMessage struct {
+	Nicks []string
+}
+
+func (m IsonMessage) GetType() MessageType {
+	return ISON
+}
+
+func (m IsonMessage) Serialize() string {
+	return fmt.Sprintf("ISON %s", strings.Join(m.Nicks, " "))
+}
+
+/* -------------------------------------------------------------------------- */
+type UserhostMessage struct {
+	Nicks []string
+}
+
+func (m UserhostMessage) GetType() MessageType {
+	return USERHOST
+}
+
+func (m UserhostMessage) Serialize() string {
+	return fmt.Sprintf("USERHOST %s", strings.Join(m.Nicks, " "))
+}
+
+/* -------------------------------------------------------------------------- */
+// AwayMessage marks the user away, or back if the message is empty.
+type AwayMessage struct {
+	Message string
+}
+
+func (m AwayMessage) GetType() MessageType {
+	return AWAY
+}
+
+func (m AwayMessage) Serialize() string {
+	if m.Message == "" {
+		return "AWAY"
+	}
+
+	return fmt.Sprintf("AWAY :%s", m.Message)
 }
@@ -0,0 +1,188 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver is the part of net.Resolver needed for client hostnames, tests
+// can swap in a fake with SetResolver.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+type cachedHostname struct {
+	hostname string
+	expires  time.Time
+}
+
+type hostnameResolver struct {
+	resolver Resolver
+
+	mutex sync.Mutex
+	cache map[string]cachedHostname
+
+	// nextExpiry is when expired names are dropped from the cache next
+	nextExpiry time.Time
+}
+
+var hostnames = newHostnameResolver(net.DefaultResolver)
+
+func newHostnameResolver(resolver Resolver) *hostnameResolver {
+	r := new(hostnameResolver)
+	r.resolver = resolver
+	r.cache = make(map[string]cachedHostname)
+
+	return r
+}
+
+func SetResolver(resolver Resolver) {
+	hostnames = newHostnameResolver(resolver)
+}
+
+func validHostname(name string) bool {
+	if name == "" || len(name) > 63 {
+		return false
+	}
+
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// lookup returns the forward confirmed name of the address, i.e. a PTR
+// name that resolves back to the same address, or "" if there is none.
+// Results, misses included, are cached for DNSCacheTTL.
+func (r *hostnameResolver) lookup(ip net.IP) string {
+	key := ip.String()
+
+	r.mutex.Lock()
+	cached, ok := r.cache[key]
+	r.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.hostname
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		config.Config.DNSTimeout)
+	defer cancel()
+
+	hostname := ""
+	names, err := r.resolver.LookupAddr(ctx, key)
+	if err != nil {
+		log.Printf("Reverse lookup of %s failed: %v", key, err)
+	}
+
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		if !validHostname(name) {
+			continue
+		}
+
+		if r.confirm(ctx, name, ip) {
+			hostname = name
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		// timed out lookups are not worth remembering
+		return hostname
+	}
+
+	now := time.Now()
+	r.mutex.Lock()
+	r.cache[key] = cachedHostname{hostname, now.Add(config.Config.DNSCacheTTL)}
+	r.expire(now)
+	r.mutex.Unlock()
+
+	return hostname
+}
+
+// expire drops the names that have expired from the cache, at most once
+// per DNSCacheTTL so that the cache holds no more than the addresses seen
+// in two of them. The mutex must be held.
+func (r *hostnameResolver) expire(now time.Time) {
+	if now.Before(r.nextExpiry) {
+		return
+	}
+
+	for key, cached := range r.cache {
+		if !now.Before(cached.expires) {
+			delete(r.cache, key)
+		}
+	}
+
+	r.nextExpiry = now.Add(config.Config.DNSCacheTTL)
+}
+
+func (r *hostnameResolver) confirm(ctx context.Context, name string,
+	ip net.IP) bool {
+	addrs, err := r.resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		if addr.IP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HostFromIP formats an address for use in a hostmask. An IPv6 address
+// starting with a colon would be taken for a trailing parameter, so "::1"
+// is written as "0::1".
+func HostFromIP(ip net.IP) string {
+	host := ip.String()
+	if strings.HasPrefix(host, ":") {
+		host = "0" + host
+	}
+
+	return host
+}
+
+// lookupHostname resolves the client's hostname in the background. The
+// channel yields the hostname, or the address itself if the lookup fails.
+func (conn *IrcConnection) lookupHostname() chan string {
+	result := make(chan string, 1)
+
+	ip := conn.RemoteIP()
+	if ip == nil {
+		// unix domain sockets have no address to speak of
+		result <- "localhost"
+		return result
+	}
+
+	conn.authNotice("*** Looking up your hostname...")
+
+	go func() {
+		hostname := hostnames.lookup(ip)
+		if hostname == "" {
+			conn.authNotice("*** Couldn't look up your hostname")
+			result <- HostFromIP(ip)
+			return
+		}
+
+		conn.authNotice("*** Found your hostname")
+		result <- hostname
+	}()
+
+	return result
+}
@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	ptr     map[string][]string
+	forward map[string][]string
+	lookups int
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context,
+	addr string) ([]string, error) {
+	r.lookups += 1
+	names, ok := r.ptr[addr]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+
+	return names, nil
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context,
+	host string) ([]net.IPAddr, error) {
+	addrs := []net.IPAddr{}
+	for _, a := range r.forward[host] {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(a)})
+	}
+
+	return addrs, nil
+}
+
+func TestHostnameResolver(t *testing.T) {
+	fake := &fakeResolver{
+		ptr: map[string][]string{
+			"192.0.2.1":   {"spoofed.example.org.", "host.example.org."},
+			"192.0.2.2":   {"liar.example.org."},
+			"2001:db8::1": {"v6.example.org."},
+		},
+		forward: map[string][]string{
+			"host.example.org": {"192.0.2.1"},
+			"liar.example.org": {"192.0.2.99"},
+			"v6.example.org":   {"2001:db8::1"},
+		},
+	}
+	r := newHostnameResolver(fake)
+
+	assert.Equal(t, r.lookup(net.ParseIP("192.0.2.1")), "host.example.org",
+		"Forward confirmed name not picked")
+	assert.Equal(t, r.lookup(net.ParseIP("192.0.2.2")), "",
+		"Unconfirmed name accepted")
+	assert.Equal(t, r.lookup(net.ParseIP("2001:db8::1")), "v6.example.org",
+		"IPv6 address not resolved")
+	assert.Equal(t, r.lookup(net.ParseIP("192.0.2.3")), "",
+		"Missing PTR not handled")
+
+	lookups := fake.lookups
+	r.lookup(net.ParseIP("192.0.2.1"))
+	r.lookup(net.ParseIP("192.0.2.3"))
+	assert.Equal(t, fake.lookups, lookups, "Cached results looked up again")
+}
+
+func TestHostnameCacheExpiry(t *testing.T) {
+	r := newHostnameResolver(&fakeResolver{})
+	now := time.Now()
+	r.cache["192.0.2.1"] = cachedHostname{"", now.Add(-time.Second)}
+	r.cache["192.0.2.2"] = cachedHostname{"", now.Add(time.Minute)}
+
+	r.expire(now)
+	assert.Equal(t, len(r.cache), 1, "Expired name kept")
+
+	r.cache["192.0.2.1"] = cachedHostname{"", now.Add(-time.Second)}
+	r.expire(now)
+	assert.Equal(t, len(r.cache), 2, "Expired again before DNSCacheTTL")
+}
+
+func TestHostFromIP(t *testing.T) {
+	assert.Equal(t, HostFromIP(net.ParseIP("::1")), "0::1",
+		"Leading colon not padded")
+	assert.Equal(t, HostFromIP(net.ParseIP("2001:db8::1")), "2001:db8::1",
+		"IPv6 address mangled")
+	assert.Equal(t, HostFromIP(net.ParseIP("192.0.2.1")), "192.0.2.1",
+		"IPv4 address mangled")
+}
@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+)
+
+// Response carries the replies to one client command. Replies to a command
+// the client labeled are held back until the command has been handled and
+// then sent with the label: alone if there is one, in a labeled-response
+// batch if there are more and as an ACK if there are none.
+//
+// A command handled partly by the server and partly by a channel passes
+// its Response on to the channel, whichever handles it last closes it.
+type Response struct {
+	conn  *IrcConnection
+	label string
+	lines []*Outgoing
+}
+
+// NewResponse starts the response to a command received with the given
+// metadata. Labels are ignored unless the client negotiated
+// labeled-response.
+func (conn *IrcConnection) NewResponse(metadata Metadata) *Response {
+	r := &Response{conn: conn}
+	if conn.Capabilities().Has(CapLabeledResponse) {
+		r.label = metadata.Label
+	}
+
+	return r
+}
+
+// Conn is the connection the response goes to.
+func (r *Response) Conn() *IrcConnection {
+	return r.conn
+}
+
+func (r *Response) Send(line string) {
+	r.SendOutgoing(NewOutgoing("", UnknownMessage{line}, Metadata{}))
+}
+
+func (r *Response) SendMessage(message IrcMessage) {
+	r.Send(message.Serialize())
+}
+
+func (r *Response) SendOutgoing(o *Outgoing) {
+	if r.label == "" {
+		r.conn.SendOutgoing(o)
+		return
+	}
+
+	if o.Serialize(r.conn.Capabilities()) != "" {
+		r.lines = append(r.lines, o)
+	}
+}
+
+func (r *Response) Close() {
+	if r.label == "" {
+		return
+	}
+
+	switch {
+	case len(r.lines) == 0:
+		ack := NewOutgoing(config.Config.ServerID, UnknownMessage{"ACK"},
+			Metadata{})
+		ack.Label = r.label
+		r.conn.SendOutgoing(ack)
+	case len(r.lines) == 1:
+		r.conn.SendOutgoing(r.lines[0].within(r.label, nil))
+	case !r.conn.Capabilities().Has(CapBatch):
+		for _, o := range r.lines {
+			r.conn.SendOutgoing(o)
+		}
+	default:
+		batch := NewBatch("labeled-response")
+		r.conn.SendOutgoing(batch.Start().within(r.label, nil))
+
+		for _, o := range r.lines {
+			r.conn.SendOutgoing(o.within("", batch))
+		}
+
+		r.conn.SendOutgoing(batch.End())
+	}
+
+	r.lines = nil
+}
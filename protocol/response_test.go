@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"strings"
+	"testing"
+)
+
+func newTestConnection(caps Capability) *IrcConnection {
+	conn := &IrcConnection{caps: caps}
+	conn.class = &config.Class{SendQ: 1 << 20}
+	conn.outgoing = make(chan bool, 1)
+
+	return conn
+}
+
+func TestResponse(t *testing.T) {
+	config.Config.ServerID = "irc.example"
+	caps := CapBatch | CapLabeledResponse
+	labeled := Metadata{Label: "abc"}
+
+	conn := newTestConnection(caps)
+	conn.NewResponse(labeled).Close()
+	assert.Equal(t, conn.sendq, []string{"@label=abc :irc.example ACK"},
+		"Empty response not acknowledged")
+
+	conn = newTestConnection(caps)
+	r := conn.NewResponse(labeled)
+	r.Send(":irc.example 381 a :You are now an IRC operator")
+	assert.Equal(t, len(conn.sendq), 0, "Labeled reply not held back")
+	r.Close()
+	assert.Equal(t, conn.sendq, []string{
+		"@label=abc :irc.example 381 a :You are now an IRC operator"},
+		"Single reply not labeled")
+
+	conn = newTestConnection(caps)
+	r = conn.NewResponse(labeled)
+	r.Send("one")
+	r.Send("two")
+	r.Close()
+	assert.Equal(t, len(conn.sendq), 4, "Replies not batched")
+	assert.True(t, strings.HasPrefix(conn.sendq[0],
+		"@label=abc :irc.example BATCH +"), "Batch not labeled")
+	assert.True(t, strings.HasPrefix(conn.sendq[1], "@batch="),
+		"Reply not in batch")
+
+	conn = newTestConnection(CapBatch)
+	r = conn.NewResponse(labeled)
+	r.Send("one")
+	assert.Equal(t, conn.sendq, []string{"one"},
+		"Label used without labeled-response")
+	r.Close()
+}
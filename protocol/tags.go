@@ -0,0 +1,179 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TimeFormat is the IRCv3 server-time format.
+const TimeFormat = "2006-01-02T15:04:05.000Z"
+
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Tags are IRCv3 message tags, kept in the order they were given.
+type Tags []Tag
+
+var tagEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\:", " ", "\\s",
+	"\r", "\\r", "\n", "\\n")
+
+func unescapeTagValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		if i == len(value) {
+			break
+		}
+
+		switch value[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+
+	return b.String()
+}
+
+// ParseTags reads the tags of a line, without the leading '@'. Of tags
+// given more than once the last one counts.
+func ParseTags(s string) Tags {
+	tags := Tags{}
+	for _, tag := range strings.Split(s, ";") {
+		if tag == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(tag, "=")
+		tags = tags.With(key, unescapeTagValue(value))
+	}
+
+	return tags
+}
+
+// SplitTags separates the tags from the rest of a line.
+func SplitTags(line string) (Tags, string) {
+	if !strings.HasPrefix(line, "@") {
+		return nil, line
+	}
+
+	tags, rest, _ := strings.Cut(line[1:], " ")
+	return ParseTags(tags), strings.TrimLeft(rest, " ")
+}
+
+func (tags Tags) Get(key string) (string, bool) {
+	for _, tag := range tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// With returns the tags with key set to value.
+func (tags Tags) With(key, value string) Tags {
+	result := Tags{}
+	for _, tag := range tags {
+		if tag.Key != key {
+			result = append(result, tag)
+		}
+	}
+
+	return append(result, Tag{key, value})
+}
+
+// ClientOnly returns the tags meant for other clients, those prefixed with
+// '+'.
+func (tags Tags) ClientOnly() Tags {
+	result := Tags{}
+	for _, tag := range tags {
+		if strings.HasPrefix(tag.Key, "+") {
+			result = append(result, tag)
+		}
+	}
+
+	return result
+}
+
+// Serialize returns the tags as they start a line, with the '@' and the
+// trailing space, or nothing if there are none.
+func (tags Tags) Serialize() string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('@')
+	for i, tag := range tags {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+
+		b.WriteString(tag.Key)
+		if tag.Value != "" {
+			b.WriteByte('=')
+			b.WriteString(tagEscaper.Replace(tag.Value))
+		}
+	}
+	b.WriteByte(' ')
+
+	return b.String()
+}
+
+var (
+	messageIDPrefix  = newMessageIDPrefix()
+	messageIDCounter atomic.Uint64
+)
+
+func newMessageIDPrefix() string {
+	prefix := make([]byte, 5)
+	rand.Read(prefix)
+
+	return strings.ToLower(
+		base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(prefix))
+}
+
+// NewMessageID returns an id unique to this process, the random prefix
+// keeps it apart from the ids of earlier processes.
+func NewMessageID() string {
+	return messageIDPrefix +
+		strconv.FormatUint(messageIDCounter.Add(1), 36)
+}
+
+// Metadata is what is known of a message besides its content: the id and
+// time given to it when the server received it, the tags the client sent
+// along for other clients and the label it wants on the response.
+type Metadata struct {
+	ID    string
+	Time  time.Time
+	Tags  Tags
+	Label string
+}
+
+func NewMetadata(tags Tags) Metadata {
+	label, _ := tags.Get("label")
+	return Metadata{NewMessageID(), time.Now(), tags.ClientOnly(), label}
+}
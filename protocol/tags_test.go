@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+	"time"
+)
+
+func TestSplitTags(t *testing.T) {
+	tags, line := SplitTags("@+draft/reply=abc;+x=a\\sb\\:c;label PRIVMSG #a :hi")
+	assert.Equal(t, line, "PRIVMSG #a :hi", "Tags not removed")
+	assert.Equal(t, tags, Tags{{"+draft/reply", "abc"}, {"+x", "a b;c"},
+		{"label", ""}}, "Tags parsed incorrectly")
+	assert.Equal(t, tags.ClientOnly().Serialize(),
+		"@+draft/reply=abc;+x=a\\sb\\:c ", "Tags serialized incorrectly")
+
+	tags, line = SplitTags("PING :x")
+	assert.Equal(t, line, "PING :x", "Untagged line changed")
+	assert.Equal(t, len(tags), 0, "Tags found in an untagged line")
+}
+
+func TestOutgoing(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	o := NewOutgoing("a!b@c", PrivateMessage{"#x", "hi"},
+		Metadata{"id1", when, Tags{{"+y", "z"}}, ""})
+
+	assert.Equal(t, o.Serialize(0), ":a!b@c PRIVMSG #x :hi",
+		"Tags sent without capabilities")
+	assert.Equal(t, o.Serialize(CapServerTime),
+		"@time=2020-01-02T03:04:05.006Z :a!b@c PRIVMSG #x :hi",
+		"Time not sent")
+	assert.Equal(t, o.Serialize(CapServerTime|CapMessageTags),
+		"@time=2020-01-02T03:04:05.006Z;msgid=id1;+y=z :a!b@c PRIVMSG #x :hi",
+		"Message tags not sent")
+
+	join := NewOutgoing("a!b@c", JoinMessage{"#x", "*", "Real Name"},
+		Metadata{})
+	assert.Equal(t, join.Serialize(0), ":a!b@c JOIN :#x",
+		"Extended join sent without the capability")
+	assert.Equal(t, join.Serialize(CapExtendedJoin),
+		":a!b@c JOIN #x * :Real Name", "Extended join not sent")
+
+	change := NewOutgoing("a!b@c", HostChangeMessage{"b", "d"}, Metadata{})
+	assert.Equal(t, change.Serialize(CapSetName), "",
+		"CHGHOST sent without the capability")
+
+	tag := NewOutgoing("a!b@c", TagMessage{"#x"}, Metadata{})
+	assert.Equal(t, tag.Serialize(CapServerTime), "",
+		"TAGMSG sent without message-tags")
+}
+
+func TestCapReply(t *testing.T) {
+	conn := &IrcConnection{}
+
+	reply := conn.CapReply(CapMessage{"REQ", "server-time message-tags"}, "*")
+	assert.Contains(t, reply, "ACK :server-time message-tags",
+		"Request not acknowledged")
+	assert.Equal(t, conn.Capabilities(), CapServerTime|CapMessageTags,
+		"Capabilities not enabled")
+
+	reply = conn.CapReply(CapMessage{"REQ", "-server-time bogus"}, "*")
+	assert.Contains(t, reply, "NAK", "Unknown capability accepted")
+	assert.Equal(t, conn.Capabilities(), CapServerTime|CapMessageTags,
+		"Refused request applied")
+
+	conn.CapReply(CapMessage{"REQ", "-server-time"}, "*")
+	assert.Equal(t, conn.Capabilities(), CapMessageTags,
+		"Capability not removed")
+}
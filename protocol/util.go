@@ -8,6 +8,11 @@ import (
 	"time"
 )
 
+// commandWord is the command of a line without tags, upper-cased.
+func commandWord(line string) string {
+	return strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+}
+
 func GetSerializedMessageFrom(from string,
 	message IrcMessage) string {
 	return fmt.Sprintf(":%s %s", from, message.Serialize())
@@ -16,34 +21,255 @@ func GetSerializedMessageFrom(from string,
 func ParseMessage(message string) IrcMessage {
 	split := strings.SplitN(message, " ", 2)
 	switch command := split[0]; command {
+	case "PING":
+		if len(split) < 2 {
+			return PingMessage{""}
+		}
+
+		return PingMessage{lastParam(split[1])}
 	case "PONG":
-		return PongMessage{split[1][1:]}
+		if len(split) < 2 {
+			return InvalidMessage{command}
+		}
+
+		return PongMessage{"", lastParam(split[1])}
 	case "NICK":
-		return NickMessage{split[1]}
+		params := parseParams(split)
+		if len(params) == 0 {
+			return InvalidMessage{command}
+		}
+
+		return NickMessage{params[0]}
 	case "USER":
 		split = strings.SplitN(message, " ", 5)
 		if len(split) != 5 {
-			return UnknownMessage{message}
+			return InvalidMessage{command}
 		}
 
 		username := split[1]
 		hostname := split[2]
-		realname := split[4][1:]
+		realname := strings.TrimPrefix(split[4], ":")
 		return UserMessage{username, realname, hostname}
 	case "PRIVMSG":
-		split = strings.SplitN(message, " ", 3)
-		return PrivateMessage{split[1], split[2][1:]}
+		// a missing target or text is left for the server to report
+		params := append(parseParams(split), "", "")
+		return PrivateMessage{params[0], params[1]}
+	case "NOTICE":
+		params := parseParams(split)
+		if len(params) < 2 {
+			return InvalidMessage{command}
+		}
+
+		return NoticeMessage{params[0], params[1]}
 	case "JOIN":
-		return JoinMessage{split[1]}
+		params := parseParams(split)
+		if len(params) == 0 {
+			return InvalidMessage{command}
+		}
+
+		return JoinMessage{params[0], "", ""}
 	case "PART":
-		return PartMessage{split[1]}
+		params := parseParams(split)
+		if len(params) == 0 {
+			return InvalidMessage{command}
+		}
+
+		return PartMessage{params[0]}
 	case "QUIT":
-		return QuitMessage{split[1][1:]}
+		if len(split) < 2 {
+			return QuitMessage{""}
+		}
+
+		return QuitMessage{lastParam(split[1])}
+	case "OPER":
+		params := parseParams(split)
+		if len(params) < 2 {
+			return InvalidMessage{command}
+		}
+
+		return OperMessage{params[0], params[1]}
+	case "DIE":
+		return DieMessage{}
+	case "RESTART", "UPGRADE":
+		return RestartMessage{}
+	case "MOTD":
+		params := append(parseParams(split), "")
+		return MotdMessage{params[0]}
+	case "REHASH":
+		return RehashMessage{}
+	case "LUSERS":
+		return LusersMessage{}
+	case "VERSION":
+		return VersionMessage{}
+	case "TIME":
+		return TimeMessage{}
+	case "ADMIN":
+		return AdminMessage{}
+	case "INFO":
+		return InfoMessage{}
+	case "STATS":
+		params := parseParams(split)
+		if len(params) == 0 {
+			return InvalidMessage{command}
+		}
+
+		return StatsMessage{params[0]}
+	case "MODE":
+		params := parseParams(split)
+		if len(params) == 0 {
+			return InvalidMessage{command}
+		}
+
+		return ModeMessage{params[0], strings.Join(params[1:], " ")}
+	case "CAP":
+		params := parseParams(split)
+		if len(params) == 0 {
+			return InvalidMessage{command}
+		}
+
+		return CapMessage{strings.ToUpper(params[0]),
+			strings.Join(params[1:], " ")}
+	case "TAGMSG":
+		params := parseParams(split)
+		if len(params) == 0 {
+			return InvalidMessage{command}
+		}
+
+		return TagMessage{params[0]}
+	case "SETNAME":
+		if len(split) < 2 {
+			return InvalidMessage{command}
+		}
+
+		return SetNameMessage{lastParam(split[1])}
+	case "CHGHOST":
+		params := parseParams(split)
+		if len(params) < 2 {
+			return InvalidMessage{command}
+		}
+
+		return ChgHostMessage{params[0], params[1]}
+	case "MONITOR":
+		params := parseParams(split)
+		if len(params) == 0 {
+			return InvalidMessage{command}
+		}
+
+		targets := []string{}
+		if len(params) > 1 {
+			for _, target := range strings.Split(params[1], ",") {
+				if target != "" {
+					targets = append(targets, target)
+				}
+			}
+		}
+
+		return MonitorMessage{strings.ToUpper(params[0]), targets}
+	case "ISON":
+		// some clients send the nicks as a single trailing parameter
+		return IsonMessage{strings.Fields(strings.Join(parseParams(split),
+			" "))}
+	case "USERHOST":
+		return UserhostMessage{strings.Fields(
+			strings.Join(parseParams(split), " "))}
+	case "AWAY":
+		if len(split) < 2 {
+			return AwayMessage{""}
+		}
+
+		return AwayMessage{lastParam(split[1])}
+	case "CHATHISTORY":
+		params := parseParams(split)
+		if len(params) == 0 {
+			return InvalidMessage{command}
+		}
+
+		return ChatHistoryMessage{strings.ToUpper(params[0]), params[1:]}
 	default:
 		return UnknownMessage{message}
 	}
 }
 
+// parseParams splits the parameters following the command into middle
+// parameters and the trailing one.
+func parseParams(split []string) []string {
+	params := []string{}
+	if len(split) < 2 {
+		return params
+	}
+
+	rest := split[1]
+	for rest != "" {
+		if rest[0] == ':' {
+			params = append(params, rest[1:])
+			break
+		}
+
+		i := strings.IndexByte(rest, ' ')
+		if i < 0 {
+			params = append(params, rest)
+			break
+		}
+
+		if i > 0 {
+			params = append(params, rest[:i])
+		}
+		rest = rest[i+1:]
+	}
+
+	return params
+}
+
+// lastParam returns the final parameter of a parameter string, which is the
+// trailing parameter when there is one.
+func lastParam(params string) string {
+	if strings.HasPrefix(params, ":") {
+		return params[1:]
+	}
+
+	if i := strings.Index(params, " :"); i >= 0 {
+		return params[i+2:]
+	}
+
+	fields := strings.Fields(params)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[len(fields)-1]
+}
+
+// MatchMask reports whether s matches the case insensitive glob mask, where
+// '*' matches any run of characters and '?' any single character.
+func MatchMask(mask, s string) bool {
+	mask = strings.ToLower(mask)
+	s = strings.ToLower(s)
+
+	star, backtrack := -1, 0
+	i, j := 0, 0
+	for j < len(s) {
+		switch {
+		case i < len(mask) && (mask[i] == '?' || mask[i] == s[j]):
+			i++
+			j++
+		case i < len(mask) && mask[i] == '*':
+			star, backtrack = i, j
+			i++
+		case star >= 0:
+			backtrack++
+			i, j = star+1, backtrack
+		default:
+			return false
+		}
+	}
+
+	for i < len(mask) && mask[i] == '*' {
+		i++
+	}
+
+	return i == len(mask)
+}
+
 func WriteLine(conn net.Conn, message string) error {
 	buff := fmt.Sprintf("%s\r\n", message)
 	sent := 0
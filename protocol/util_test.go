@@ -0,0 +1,52 @@
+package protocol
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMatchMask(t *testing.T) {
+	assert.True(t, MatchMask("*", "host.example.org"), "* should match all")
+	assert.True(t, MatchMask("*.example.org", "Host.Example.ORG"),
+		"Mask should match case insensitively")
+	assert.True(t, MatchMask("10.0.?.*", "10.0.1.200"),
+		"? should match a single character")
+	assert.True(t, MatchMask("*!*@*.example.org", "juke!juke@a.example.org"),
+		"Hostmask not matched")
+	assert.False(t, MatchMask("*.example.org", "example.org"),
+		"Mask matched too much")
+	assert.False(t, MatchMask("10.0.?.1", "10.0.10.1"),
+		"? matched more than one character")
+}
+
+func TestLastParam(t *testing.T) {
+	assert.Equal(t, lastParam(":token"), "token", "Trailing not parsed")
+	assert.Equal(t, lastParam("server :some token"), "some token",
+		"Trailing not parsed")
+	assert.Equal(t, lastParam("server token"), "token",
+		"Last middle param not parsed")
+}
+
+func TestParseParams(t *testing.T) {
+	params := parseParams([]string{"OPER", "juke  secret"})
+	assert.Equal(t, params, []string{"juke", "secret"},
+		"Middle params parsed incorrectly")
+
+	params = parseParams([]string{"USER", "juke 0 * :Real Juke"})
+	assert.Equal(t, params, []string{"juke", "0", "*", "Real Juke"},
+		"Trailing param parsed incorrectly")
+
+	params = parseParams([]string{"DIE"})
+	assert.Equal(t, params, []string{}, "Missing params parsed incorrectly")
+}
+
+func TestParsePresenceQueries(t *testing.T) {
+	assert.Equal(t, ParseMessage("ISON alice :bob carol"),
+		IsonMessage{[]string{"alice", "bob", "carol"}},
+		"ISON nicks parsed incorrectly")
+	assert.Equal(t, ParseMessage("USERHOST alice bob"),
+		UserhostMessage{[]string{"alice", "bob"}},
+		"USERHOST nicks parsed incorrectly")
+	assert.Equal(t, ParseMessage("AWAY"), AwayMessage{""},
+		"AWAY without a message parsed incorrectly")
+}
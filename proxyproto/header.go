@@ -0,0 +1,124 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// ReadHeader reads a version 1 or 2 PROXY protocol header and returns the
+// source address it carries. A nil address means the proxy did not tell,
+// e.g. for health checks, and the peer's own address should be used.
+func ReadHeader(reader *bufio.Reader) (net.Addr, error) {
+	start, err := reader.Peek(len(signatureV2))
+	if err != nil && len(start) < 6 {
+		return nil, err
+	}
+
+	if bytes.Equal(start, signatureV2) {
+		return readV2(reader)
+	}
+
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readV1(reader)
+	}
+
+	return nil, ErrNoHeader
+}
+
+// readV1 parses the text form, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 6667\r\n".
+func readV1(reader *bufio.Reader) (net.Addr, error) {
+	line := []byte{}
+	for len(line) < 107 {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1 header too long")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 0xffff {
+		return nil, fmt.Errorf("malformed PROXY v1 address %q", line)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	version := header[12] >> 4
+	command := header[12] & 0x0f
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:])
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	if version != 2 {
+		return nil, fmt.Errorf("unsupported PROXY version %d", version)
+	}
+
+	// LOCAL connections come from the proxy itself
+	if command == 0x0 {
+		return nil, nil
+	}
+
+	if command != 0x1 {
+		return nil, fmt.Errorf("unsupported PROXY command %d", command)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short PROXY v2 IPv4 address")
+		}
+
+		return &net.TCPAddr{IP: net.IP(body[0:4]),
+			Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short PROXY v2 IPv6 address")
+		}
+
+		return &net.TCPAddr{IP: net.IP(body[0:16]),
+			Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	default:
+		return nil, nil
+	}
+}
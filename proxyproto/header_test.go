@@ -0,0 +1,54 @@
+package proxyproto
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+func read(header string) (net.Addr, *bufio.Reader, error) {
+	reader := bufio.NewReader(strings.NewReader(header))
+	addr, err := ReadHeader(reader)
+
+	return addr, reader, err
+}
+
+func TestReadHeaderV1(t *testing.T) {
+	addr, reader, err := read(
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 6667\r\nNICK juke\r\n")
+	assert.NoError(t, err, "Valid header rejected")
+	assert.Equal(t, addr.String(), "192.0.2.1:56324", "Source parsed incorrectly")
+	rest, _ := reader.ReadString('\n')
+	assert.Equal(t, rest, "NICK juke\r\n", "Header not consumed exactly")
+
+	addr, _, err = read("PROXY TCP6 2001:db8::1 2001:db8::2 4000 6667\r\n")
+	assert.NoError(t, err, "Valid header rejected")
+	assert.Equal(t, addr.String(), "[2001:db8::1]:4000",
+		"IPv6 source parsed incorrectly")
+
+	addr, _, err = read("PROXY UNKNOWN\r\n")
+	assert.NoError(t, err, "UNKNOWN header rejected")
+	assert.Nil(t, addr, "UNKNOWN header should not carry an address")
+
+	_, _, err = read("NICK juke\r\n")
+	assert.Equal(t, err, ErrNoHeader, "Missing header not detected")
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	header := string(signatureV2) + "\x21\x11\x00\x0c" +
+		"\xc0\x00\x02\x01" + "\xc6\x33\x64\x01" + "\xdc\x04" + "\x1a\x0b" +
+		"NICK juke\r\n"
+
+	addr, reader, err := read(header)
+	assert.NoError(t, err, "Valid header rejected")
+	assert.Equal(t, addr.String(), "192.0.2.1:56324", "Source parsed incorrectly")
+	rest, _ := reader.ReadString('\n')
+	assert.Equal(t, rest, "NICK juke\r\n", "Header not consumed exactly")
+
+	addr, _, err = read(string(signatureV2) + "\x20\x00\x00\x00")
+	assert.NoError(t, err, "LOCAL header rejected")
+	assert.Nil(t, addr, "LOCAL header should not carry an address")
+}
@@ -0,0 +1,155 @@
+package proxyproto
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn is a connection whose remote address was given by the proxy.
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// NetConn is the connection from the proxy.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Buffered returns what has been read from the proxy past the header but
+// not consumed yet.
+func (c *Conn) Buffered() []byte {
+	buffered, _ := c.reader.Peek(c.reader.Buffered())
+	return buffered
+}
+
+// Listener accepts connections from trusted proxies only and hands them
+// out once their PROXY header has been read. Headers are read in the
+// background so that a slow proxy does not hold up the others.
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+
+	ready     chan net.Conn
+	errors    chan error
+	done      chan bool
+	closeOnce sync.Once
+}
+
+func NewListener(inner net.Listener, trusted []string,
+	timeout time.Duration) *Listener {
+	l := new(Listener)
+	l.Listener = inner
+	l.timeout = timeout
+	l.ready = make(chan net.Conn)
+	l.errors = make(chan error)
+	l.done = make(chan bool)
+
+	for _, cidr := range trusted {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxy %s: %v", cidr, err)
+			continue
+		}
+
+		l.trusted = append(l.trusted, network)
+	}
+
+	go l.acceptRoutine()
+
+	return l
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, network := range l.trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *Listener) acceptRoutine() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errors <- err:
+			case <-l.done:
+				return
+			}
+
+			continue
+		}
+
+		go l.readHeader(conn)
+	}
+}
+
+func (l *Listener) readHeader(conn net.Conn) {
+	if !l.isTrusted(conn.RemoteAddr()) {
+		log.Printf("Rejected PROXY connection from untrusted %v",
+			conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(l.timeout))
+	reader := bufio.NewReader(conn)
+	remote, err := ReadHeader(reader)
+	if err != nil {
+		log.Printf("Reading PROXY header from %v failed: %v",
+			conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+
+	select {
+	case l.ready <- &Conn{conn, reader, remote}:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.ready:
+		return conn, nil
+	case err := <-l.errors:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+
+	return l.Listener.Close()
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/history"
+	"github.com/jukeks/channeld/protocol"
+
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// endOfTime bounds queries reaching up to the present.
+var endOfTime = time.Unix(1<<40, 0)
+
+// chatHistoryParams are the subcommands and the number of parameters they
+// take.
+var chatHistoryParams = map[string]int{
+	"LATEST":  3,
+	"BEFORE":  3,
+	"AFTER":   3,
+	"AROUND":  3,
+	"BETWEEN": 4,
+	"TARGETS": 3,
+}
+
+// isMessage selects what is played back: joins, parts and the like are
+// recorded but left out, as clients do not expect them in history.
+func isMessage(entry history.Entry) bool {
+	return entry.Command == "PRIVMSG" || entry.Command == "NOTICE"
+}
+
+// historyKey finds the history of a target the user may read, a channel
+// they are on or their conversation with the user now using a nick. What
+// was said to an earlier holder of the nick is not theirs to read.
+//
+// Without accounts there is nothing to tell a returning user from someone
+// else taking their nick, so a private conversation is kept under the ids
+// of its two users. It can only be read while both of them are connected:
+// once either one reconnects, it is out of reach for good.
+func (server *Server) historyKey(user *User, target string) (string, bool) {
+	if isChannelName(target) {
+		return target, user.channels[target]
+	}
+
+	other := server.getUserByName(target)
+	if other == nil {
+		return "", false
+	}
+
+	return history.PrivateKey(user.id, other.id), true
+}
+
+// parseSelector reads a timestamp= or msgid= message selector into the
+// time of the selected message.
+func (server *Server) parseSelector(key, selector string) (time.Time, bool) {
+	switch {
+	case strings.HasPrefix(selector, "timestamp="):
+		t, err := time.Parse(time.RFC3339Nano,
+			strings.TrimPrefix(selector, "timestamp="))
+		return t, err == nil
+	case strings.HasPrefix(selector, "msgid="):
+		entry, ok := server.history.Find(key,
+			strings.TrimPrefix(selector, "msgid="))
+		return entry.Time, ok
+	default:
+		return time.Time{}, false
+	}
+}
+
+func parseLimit(limit string) (int, bool) {
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	if n > config.Config.HistoryQueryLimit {
+		n = config.Config.HistoryQueryLimit
+	}
+
+	return n, true
+}
+
+// handleChatHistory plays back history to clients that negotiated
+// draft/chathistory, to others the command is unknown.
+func (server *Server) handleChatHistory(user *User,
+	message protocol.ChatHistoryMessage, response *protocol.Response) {
+	if !user.conn.Capabilities().Has(protocol.CapChatHistory) {
+		response.Error(user.nick, protocol.ERR_UNKNOWNCOMMAND, "CHATHISTORY")
+		return
+	}
+
+	sub := message.Subcommand
+	params := message.Params
+
+	required, ok := chatHistoryParams[sub]
+	if !ok {
+		response.Fail("CHATHISTORY", "UNKNOWN_COMMAND", "Unknown subcommand",
+			sub)
+		return
+	}
+
+	if len(params) < required {
+		response.Fail("CHATHISTORY", "INVALID_PARAMS", "Insufficient parameters",
+			sub)
+		return
+	}
+
+	if sub == "TARGETS" {
+		server.handleChatHistoryTargets(user, params, response)
+		return
+	}
+
+	target := params[0]
+	key, ok := server.historyKey(user, target)
+	if !ok {
+		response.Fail("CHATHISTORY", "INVALID_TARGET",
+			"Messages could not be retrieved", sub, target)
+		return
+	}
+
+	limit, ok := parseLimit(params[len(params)-1])
+	if !ok {
+		response.Fail("CHATHISTORY", "INVALID_PARAMS", "Invalid limit",
+			sub)
+		return
+	}
+
+	var from time.Time
+	if sub != "LATEST" || params[1] != "*" {
+		from, ok = server.parseSelector(key, params[1])
+		if !ok {
+			response.Fail("CHATHISTORY", "INVALID_PARAMS", "Invalid message selector",
+				sub, params[1])
+			return
+		}
+	}
+
+	var entries []history.Entry
+	switch sub {
+	case "LATEST":
+		entries = server.history.Range(key, from, endOfTime, limit, true,
+			isMessage)
+	case "BEFORE":
+		entries = server.history.Range(key, time.Time{}, from, limit, true,
+			isMessage)
+	case "AFTER":
+		entries = server.history.Range(key, from, endOfTime, limit, false,
+			isMessage)
+	case "AROUND":
+		entries = server.history.Range(key, time.Time{}, from, limit/2, true,
+			isMessage)
+		entries = append(entries, server.history.Range(key,
+			from.Add(-time.Nanosecond), endOfTime, limit-len(entries), false,
+			isMessage)...)
+	case "BETWEEN":
+		to, ok := server.parseSelector(key, params[2])
+		if !ok {
+			response.Fail("CHATHISTORY", "INVALID_PARAMS", "Invalid message selector",
+				sub, params[2])
+			return
+		}
+
+		if from.Before(to) {
+			entries = server.history.Range(key, from, to, limit, false,
+				isMessage)
+		} else {
+			entries = server.history.Range(key, to, from, limit, true,
+				isMessage)
+		}
+	}
+
+	sendHistoryBatch(response, target, entries)
+}
+
+// sendHistoryBatch plays the entries back in a chathistory batch, tagged
+// with their original time and msgid as the client's capabilities allow.
+func sendHistoryBatch(response *protocol.Response, target string,
+	entries []history.Entry) {
+	batch := protocol.NewBatch("chathistory", target)
+	response.SendOutgoing(batch.Start())
+
+	for _, entry := range entries {
+		outgoing := protocol.NewOutgoing(entry.Source,
+			protocol.UnknownMessage{entry.Message},
+			protocol.Metadata{entry.ID, entry.Time, nil, ""})
+		outgoing.Batch = batch
+
+		response.SendOutgoing(outgoing)
+	}
+
+	response.SendOutgoing(batch.End())
+}
+
+// handleChatHistoryTargets lists the channels and users the user has
+// talked with between two timestamps.
+func (server *Server) handleChatHistoryTargets(user *User, params []string,
+	response *protocol.Response) {
+	var bounds [2]time.Time
+	for i, selector := range params[:2] {
+		if !strings.HasPrefix(selector, "timestamp=") {
+			response.Fail("CHATHISTORY", "INVALID_PARAMS", "Invalid timestamp",
+				"TARGETS", selector)
+			return
+		}
+
+		t, ok := server.parseSelector("", selector)
+		if !ok {
+			response.Fail("CHATHISTORY", "INVALID_PARAMS", "Invalid timestamp",
+				"TARGETS", selector)
+			return
+		}
+		bounds[i] = t
+	}
+
+	limit, ok := parseLimit(params[2])
+	if !ok {
+		response.Fail("CHATHISTORY", "INVALID_PARAMS", "Invalid limit",
+			"TARGETS")
+		return
+	}
+
+	start, end := bounds[0], bounds[1]
+	if end.Before(start) {
+		start, end = end, start
+	}
+
+	names := map[string]string{}
+	targets := server.history.Targets(start, end, limit,
+		func(key string) bool {
+			if user.channels[key] {
+				names[key] = key
+				return true
+			}
+
+			id, ok := history.PrivateKeyOwner(key, user.id)
+			if !ok {
+				return false
+			}
+
+			other := server.getUserByID(id)
+			if other == nil {
+				return false
+			}
+
+			names[key] = other.nick
+			return true
+		})
+
+	batch := protocol.NewBatch("draft/chathistory-targets")
+	response.SendOutgoing(batch.Start())
+
+	for _, target := range targets {
+		response.SendOutgoing(batch.Line(protocol.UnknownMessage{
+			fmt.Sprintf("CHATHISTORY TARGETS %s timestamp=%s",
+				names[target.Key], protocol.FormatTime(target.Latest))}))
+	}
+
+	response.SendOutgoing(batch.End())
+}
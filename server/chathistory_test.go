@@ -0,0 +1,125 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/protocol"
+	"github.com/stretchr/testify/assert"
+
+	"strings"
+	"testing"
+)
+
+func newHistoryTestClient(server *Server, nick string) *testClient {
+	c := newTestClient(server, nick)
+	c.user.conn.SetCapabilities(protocol.CapChatHistory)
+
+	return c
+}
+
+func TestChatHistoryCapability(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+
+	alice.send(server, "CHATHISTORY LATEST #x * 10")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 421 alice CHATHISTORY :Unknown command"},
+		"CHATHISTORY served without the capability")
+
+	alice.send(server, "CAP LS")
+	lines := alice.read()
+	assert.Equal(t, len(lines), 1, "No CAP LS reply")
+	assert.True(t, strings.Contains(lines[0], "draft/chathistory"),
+		"draft/chathistory not offered")
+}
+
+func TestChatHistoryChannel(t *testing.T) {
+	server := newTestServer()
+	alice := newHistoryTestClient(server, "alice")
+	defer alice.close()
+	bob := newHistoryTestClient(server, "bob")
+	defer bob.close()
+
+	alice.send(server, "JOIN #x")
+	alice.send(server, "PRIVMSG #x :one")
+	alice.send(server, "PRIVMSG #x :two")
+	alice.send(server, "PRIVMSG #x :three")
+	alice.read()
+
+	alice.send(server, "CHATHISTORY LATEST #x * 2")
+	assert.Equal(t, alice.read(), []string{
+		":alice!~alice@example.org PRIVMSG #x :two",
+		":alice!~alice@example.org PRIVMSG #x :three",
+	}, "Latest messages not played back")
+
+	bob.send(server, "CHATHISTORY LATEST #x * 2")
+	assert.Equal(t, bob.read(), []string{
+		":irc.example FAIL CHATHISTORY INVALID_TARGET LATEST #x " +
+			":Messages could not be retrieved"},
+		"History shown outside the channel")
+
+	alice.send(server, "CHATHISTORY LATEST #x * x")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example FAIL CHATHISTORY INVALID_PARAMS LATEST :Invalid limit"},
+		"Invalid limit accepted")
+}
+
+func TestChatHistoryRemovedChannel(t *testing.T) {
+	server := newTestServer()
+	alice := newHistoryTestClient(server, "alice")
+	defer alice.close()
+
+	alice.send(server, "JOIN #x")
+	alice.send(server, "PRIVMSG #x :one")
+	alice.send(server, "PART #x")
+	alice.read()
+	server.handleEmptyChannel(<-server.emptyChannels)
+
+	alice.send(server, "JOIN #x")
+	alice.read()
+	alice.send(server, "CHATHISTORY LATEST #x * 10")
+	assert.Equal(t, len(alice.read()), 0,
+		"History of a removed channel passed on")
+}
+
+func TestChatHistoryPrivate(t *testing.T) {
+	server := newTestServer()
+	alice := newHistoryTestClient(server, "alice")
+	defer alice.close()
+	bob := newHistoryTestClient(server, "bob")
+	defer bob.close()
+
+	alice.send(server, "PRIVMSG bob :hi")
+	bob.send(server, "PRIVMSG alice :hello")
+	bob.read()
+	alice.read()
+
+	conversation := []string{
+		":alice!~alice@example.org PRIVMSG bob :hi",
+		":bob!~bob@example.org PRIVMSG alice :hello",
+	}
+
+	alice.send(server, "CHATHISTORY LATEST bob * 10")
+	assert.Equal(t, alice.read(), conversation, "Sent messages not kept")
+	bob.send(server, "CHATHISTORY LATEST alice * 10")
+	assert.Equal(t, bob.read(), conversation, "Received messages not kept")
+
+	alice.send(server, "CHATHISTORY TARGETS timestamp=2000-01-01T00:00:00Z "+
+		"timestamp=2100-01-01T00:00:00Z 10")
+	lines := alice.read()
+	assert.Equal(t, len(lines), 1, "Conversation not listed")
+	assert.True(t, strings.HasPrefix(lines[0],
+		":irc.example CHATHISTORY TARGETS bob "),
+		"Conversation not listed under the nick")
+
+	// whoever takes the nick next does not get the conversation
+	bob.send(server, "QUIT")
+	carol := newHistoryTestClient(server, "carol")
+	defer carol.close()
+	carol.send(server, "NICK bob")
+	carol.read()
+
+	carol.send(server, "CHATHISTORY LATEST alice * 10")
+	assert.Equal(t, len(carol.read()), 0, "Conversation passed on with nick")
+	alice.send(server, "CHATHISTORY LATEST bob * 10")
+	assert.Equal(t, len(alice.read()), 0, "Conversation passed on with nick")
+}
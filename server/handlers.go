@@ -2,21 +2,27 @@ package server
 
 import (
 	"github.com/jukeks/channeld/channel"
-	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/history"
 	"github.com/jukeks/channeld/protocol"
 
-	"fmt"
 	"log"
+	"strings"
 )
 
-func (server *Server) nickAvailable(nick string) bool {
-	for _, user := range server.users {
-		if user.nick == nick {
-			return false
+// casefold maps a nick to the form it is compared in, following the
+// advertised ascii casemapping.
+func casefold(nick string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
 		}
-	}
 
-	return true
+		return r
+	}, nick)
+}
+
+func (server *Server) nickAvailable(nick string) bool {
+	return server.getUserByName(nick) == nil
 }
 
 func isChannelMessage(message protocol.IrcMessage) bool {
@@ -28,18 +34,31 @@ func isChannelMessage(message protocol.IrcMessage) bool {
 	}
 }
 
-func isPrivateMessage(message protocol.IrcMessage) bool {
-	if message.GetType() != protocol.PRIVATE {
+// isChannelName tells whether a target names a channel, or could name one.
+func isChannelName(name string) bool {
+	if name == "" || (name[0] != '#' && name[0] != '!') {
 		return false
 	}
 
-	msg := message.(protocol.PrivateMessage)
-	chan_type := msg.Target[0]
-	if chan_type == '#' || chan_type == '!' {
+	return !strings.ContainsAny(name, " ,\x07")
+}
+
+func isPrivateMessage(message protocol.IrcMessage) bool {
+	switch message.GetType() {
+	case protocol.PRIVATE, protocol.NOTICE, protocol.TAGMSG:
+	default:
 		return false
 	}
 
-	return true
+	msg := message.(protocol.ChannelMessage)
+	return !isChannelName(msg.GetTarget())
+}
+
+// isUserModeMessage tells a MODE query or change on a user apart from one
+// on a channel.
+func isUserModeMessage(message protocol.IrcMessage) bool {
+	msg, ok := message.(protocol.ModeMessage)
+	return ok && !isChannelName(msg.Target)
 }
 
 func (server *Server) handleNewUser(
@@ -50,15 +69,19 @@ func (server *Server) handleNewUser(
 		user := NewUser(nickMsg.Nick, userMsg.Username, userMsg.Realname,
 			action.Hostname, action.Conn)
 		server.addUser(action.Conn, user)
-		server.sendMotd(action.Conn, user.nick)
-		action.Conn.SendMessage(protocol.PingMessage{"12345"})
+
+		response := action.Conn.NewResponse(protocol.Metadata{})
+		server.sendISupport(user.nick, response)
+		server.handleLusers(user, response)
+		server.sendMotd(action.Conn.Class(), user.nick, response)
+		response.Close()
+		action.Conn.Ping()
 
 		action.ResponseChan <- protocol.ConnectionInitiationActionResponse{true,
 			protocol.NO_ERROR, nil}
 	} else {
-		id := config.Config.ServerID
-		reply := protocol.NumericMessage{id, 433, nickMsg.Nick,
-			"Nickname is already in use."}
+		reply := protocol.NewNumeric(protocol.ERR_NICKNAMEINUSE, "*",
+			nickMsg.Nick)
 		action.ResponseChan <- protocol.ConnectionInitiationActionResponse{false,
 			protocol.NICK_IN_USE, reply}
 	}
@@ -75,94 +98,276 @@ func (server *Server) handleMessage(action protocol.ClientAction) {
 	}
 
 	if message == nil {
-		server.removeUser(conn, user, "EOF from client.")
-		log.Printf("%s has quit.", user.nick)
+		reason := conn.CloseReason()
+		if reason == "" {
+			reason = "EOF from client."
+		}
+
+		server.removeUser(conn, user, reason, protocol.NewMetadata(nil))
+		log.Printf("%s has quit: %s", user.nick, reason)
 		return
 	}
 
-	if !isPrivateMessage(message) && isChannelMessage(message) {
-		server.handleChannelMessage(user, action)
+	server.countCommand(action)
+	response := conn.NewResponse(action.Metadata)
+
+	if !isPrivateMessage(message) && !isUserModeMessage(message) &&
+		isChannelMessage(message) {
+		server.handleChannelMessage(user, action, response)
 		return
 	}
+	defer response.Close()
 
 	switch message.GetType() {
-	case protocol.PRIVATE:
-		msg := message.(protocol.PrivateMessage)
-		targetUser := server.getUserByName(msg.Target)
-		if targetUser == nil {
+	case protocol.PRIVATE, protocol.NOTICE, protocol.TAGMSG:
+		msg := message.(protocol.ChannelMessage)
+		server.handlePrivateMessage(user, msg, action.Metadata, response)
+	case protocol.PING:
+		msg := message.(protocol.PingMessage)
+		if msg.Token == "" {
+			response.Error(user.nick, protocol.ERR_NOORIGIN)
 			return
 		}
 
-		targetUser.conn.SendMessageFrom(user.hostmask(), action.Message)
+		response.Send(protocol.PongReply(msg.Token))
 	case protocol.PONG:
-		//user.lastPong = time.Now()
+		msg := message.(protocol.PongMessage)
+		if _, ok := conn.Pong(msg.Token); !ok {
+			log.Printf("%s sent PONG with unexpected token %s", user.nick,
+				msg.Token)
+		}
 	case protocol.NICK:
 		msg := message.(protocol.NickMessage)
-		server.handleNickChange(user, msg)
+		server.handleNickChange(user, msg, action.Metadata, response)
 	case protocol.USER:
 		log.Printf("")
 	case protocol.QUIT:
-		server.removeUser(conn, user, "Leaving")
+		server.removeUser(conn, user, "Leaving", action.Metadata)
 		log.Printf("%s has quit.", user.nick)
+	case protocol.OPER:
+		msg := message.(protocol.OperMessage)
+		server.handleOper(user, msg, response)
+	case protocol.DIE:
+		server.handleDie(user, response)
+	case protocol.RESTART:
+		server.handleRestart(user, response)
+	case protocol.MOTD:
+		server.sendMotd(conn.Class(), user.nick, response)
+	case protocol.REHASH:
+		server.handleRehash(user, response)
+	case protocol.LUSERS:
+		server.handleLusers(user, response)
+	case protocol.VERSION:
+		server.handleVersion(user, response)
+	case protocol.TIME:
+		server.handleTime(user, response)
+	case protocol.ADMIN:
+		server.handleAdmin(user, response)
+	case protocol.INFO:
+		server.handleInfo(user, response)
+	case protocol.STATS:
+		msg := message.(protocol.StatsMessage)
+		server.handleStats(user, msg, response)
+	case protocol.CAP:
+		msg := message.(protocol.CapMessage)
+		if reply := conn.CapReply(msg, user.nick); reply != "" {
+			response.Send(reply)
+		}
+	case protocol.SETNAME:
+		msg := message.(protocol.SetNameMessage)
+		server.handleSetName(user, msg, action.Metadata, response)
+	case protocol.CHGHOST:
+		msg := message.(protocol.ChgHostMessage)
+		server.handleChgHost(user, msg, action.Metadata, response)
+	case protocol.MONITOR:
+		msg := message.(protocol.MonitorMessage)
+		server.handleMonitor(user, msg, response)
+	case protocol.ISON:
+		msg := message.(protocol.IsonMessage)
+		server.handleIson(user, msg, response)
+	case protocol.USERHOST:
+		msg := message.(protocol.UserhostMessage)
+		server.handleUserhost(user, msg, response)
+	case protocol.AWAY:
+		msg := message.(protocol.AwayMessage)
+		server.handleAway(user, msg, response)
+	case protocol.CHATHISTORY:
+		msg := message.(protocol.ChatHistoryMessage)
+		server.handleChatHistory(user, msg, response)
+	case protocol.MODE:
+		msg := message.(protocol.ModeMessage)
+		server.handleUserMode(user, msg, response)
+	case protocol.INVALID:
+		msg := message.(protocol.InvalidMessage)
+		switch msg.Command {
+		case "NOTICE":
+			return
+		case "NICK":
+			response.Error(user.nick, protocol.ERR_NONICKNAMEGIVEN)
+			return
+		}
+
+		response.Error(user.nick, protocol.ERR_NEEDMOREPARAMS, msg.Command)
 	default:
-		log.Printf("%s sent unknown message: %s", user.nick,
-			message.Serialize())
+		command := strings.SplitN(message.Serialize(), " ", 2)[0]
+		if command == "" {
+			return
+		}
+
+		log.Printf("%s sent unknown command: %s", user.nick, command)
+		response.Error(user.nick, protocol.ERR_UNKNOWNCOMMAND, command)
 	}
 }
 
+// handleChannelMessage passes a message on to its channel, along with the
+// response to be closed there.
 func (server *Server) handleChannelMessage(user *User,
-	action protocol.ClientAction) {
+	action protocol.ClientAction, response *protocol.Response) {
 	msg := action.Message.(protocol.ChannelMessage)
 
 	c := server.getChannel(msg.GetTarget())
-	if c == nil && msg.GetType() == protocol.JOIN {
+	if c == nil && msg.GetType() == protocol.JOIN &&
+		isChannelName(msg.GetTarget()) {
 		c = server.addChannel(msg.GetTarget())
 	}
 
 	if c == nil {
+		if msg.GetType() != protocol.NOTICE {
+			response.Error(user.nick, protocol.ERR_NOSUCHCHANNEL,
+				msg.GetTarget())
+		}
+		response.Close()
 		return
 	}
 
+	if msg.GetType() == protocol.MODE && !server.modeChangeAllowed(user, c,
+		msg.(protocol.ModeMessage), response) {
+		response.Close()
+		return
+	}
+
+	switch msg.GetType() {
+	case protocol.JOIN:
+		msg = protocol.JoinMessage{c.Name, user.accountName(), user.realname}
+		user.channels[c.Name] = true
+	case protocol.PART:
+		delete(user.channels, c.Name)
+	}
+
 	c.Incoming <- protocol.ChannelAction{user.hostmask(), user.nick,
-		user.conn, msg}
+		user.conn, msg, action.Metadata, response}
+}
+
+// handlePrivateMessage delivers a PRIVMSG, NOTICE or TAGMSG to a user and
+// records the first two in the history of their conversation. Senders with
+// echo-message get the message back as delivered. A NOTICE that cannot be
+// delivered is dropped without an error, as it must never be replied to.
+func (server *Server) handlePrivateMessage(user *User,
+	message protocol.ChannelMessage, metadata protocol.Metadata,
+	response *protocol.Response) {
+	notice := message.GetType() == protocol.NOTICE
+
+	if message.GetTarget() == "" {
+		if !notice {
+			response.Error(user.nick, protocol.ERR_NORECIPIENT)
+		}
+		return
+	}
+
+	if msg, ok := message.(protocol.PrivateMessage); ok && msg.Message == "" {
+		response.Error(user.nick, protocol.ERR_NOTEXTTOSEND)
+		return
+	}
+
+	targetUser := server.getUserByName(message.GetTarget())
+	if targetUser == nil {
+		if !notice {
+			response.Error(user.nick, protocol.ERR_NOSUCHNICK,
+				message.GetTarget())
+		}
+		return
+	}
+
+	outgoing := protocol.NewOutgoing(user.hostmask(), message, metadata)
+	targetUser.conn.SendOutgoing(outgoing)
+	if targetUser.away != "" && message.GetType() == protocol.PRIVATE {
+		response.SendMessage(protocol.NewNumeric(protocol.RPL_AWAY,
+			user.nick, targetUser.nick, targetUser.away))
+	}
+	if user.conn.Capabilities().Has(protocol.CapEchoMessage) {
+		response.SendOutgoing(outgoing)
+	}
+
+	command := "PRIVMSG"
+	switch message.GetType() {
+	case protocol.TAGMSG:
+		return
+	case protocol.NOTICE:
+		command = "NOTICE"
+	}
+
+	server.history.Add(history.PrivateKey(user.id, targetUser.id),
+		history.Entry{metadata.ID, metadata.Time, user.hostmask(), command,
+			message.Serialize()})
 }
 
 func (server *Server) handleNickChange(user *User,
-	message protocol.NickMessage) {
-	if !server.nickAvailable(message.Nick) {
+	message protocol.NickMessage, metadata protocol.Metadata,
+	response *protocol.Response) {
+	if other := server.getUserByName(message.Nick); other != nil &&
+		other != user {
 		log.Printf("Nick %s already in use", message.Nick)
-		id := config.Config.ServerID
-		msg := protocol.NumericMessage{id, 433, message.Nick,
-			"Nick name is already in use."}
-		user.conn.SendMessage(msg)
+		response.Error(user.nick, protocol.ERR_NICKNAMEINUSE, message.Nick)
 		return
 	}
 
-	for _, c := range server.channels {
-		c.Incoming <- protocol.ChannelAction{user.hostmask(), user.nick,
-			user.conn, message}
-	}
+	outgoing := protocol.NewOutgoing(user.hostmask(), message, metadata)
+	server.updateMember(user, message, metadata)
+	server.notifyPeers(user, outgoing)
+	response.SendOutgoing(outgoing)
 
 	log.Printf("%s changed nick to %s", user.nick, message.Nick)
+
+	// to those monitoring it, a nick only changing case stays online
+	caseOnly := casefold(user.nick) == casefold(message.Nick)
+	if !caseOnly {
+		server.notifyMonitors(user, false)
+	}
+	delete(server.nicks, casefold(user.nick))
+
 	user.nick = message.Nick
+	server.nicks[casefold(user.nick)] = user
+	if !caseOnly {
+		server.notifyMonitors(user, true)
+	}
 }
 
 func (server *Server) addUser(conn *protocol.IrcConnection, user *User) {
 	server.users[conn] = user
+	server.nicks[casefold(user.nick)] = user
+	server.notifyMonitors(user, true)
+
+	if len(server.users) > server.maxUsers {
+		server.maxUsers = len(server.users)
+	}
 
 	log.Printf("Server has %d users", len(server.users))
 }
 
 func (server *Server) removeUser(conn *protocol.IrcConnection, user *User,
-	reason string) {
+	reason string, metadata protocol.Metadata) {
 	user.close()
 
 	for _, c := range server.channels {
 		c.Incoming <- protocol.ChannelAction{user.hostmask(), user.nick,
-			user.conn, protocol.QuitMessage{reason}}
+			user.conn, protocol.QuitMessage{reason}, metadata,
+			user.conn.NewResponse(protocol.Metadata{})}
 	}
 
 	delete(server.users, conn)
+	delete(server.nicks, casefold(user.nick))
+	server.clearMonitors(user)
+	server.notifyMonitors(user, false)
 }
 
 func (server *Server) getUserByConn(conn *protocol.IrcConnection) *User {
@@ -170,8 +375,12 @@ func (server *Server) getUserByConn(conn *protocol.IrcConnection) *User {
 }
 
 func (server *Server) getUserByName(name string) *User {
+	return server.nicks[casefold(name)]
+}
+
+func (server *Server) getUserByID(id string) *User {
 	for _, user := range server.users {
-		if user.nick == name {
+		if user.id == id {
 			return user
 		}
 	}
@@ -184,8 +393,9 @@ func (server *Server) getChannel(name string) *channel.Channel {
 }
 
 func (server *Server) addChannel(name string) *channel.Channel {
-	c := channel.NewChannel(name)
-	go c.Serve()
+	c := channel.NewChannel(name, server.store, server.history,
+		server.emptyChannels)
+	c.Start()
 
 	server.channels[name] = c
 
@@ -194,26 +404,61 @@ func (server *Server) addChannel(name string) *channel.Channel {
 	return c
 }
 
-func (server *Server) getMotd() []string {
-	return []string{
-		fmt.Sprintf("Welcome to %s running", config.Config.ServerID),
-		"     _                   _   _ ",
-		" ___| |_ ___ ___ ___ ___| |_| |",
-		"|  _|   | .'|   |   | -_| | . |",
-		"|___|_|_|__,|_|_|_|_|___|_|___|",
-		"                               ",
-		"version 0.1.0.",
+// handleUserMode shows the user their own modes, which cannot be changed
+// with MODE.
+func (server *Server) handleUserMode(user *User, message protocol.ModeMessage,
+	response *protocol.Response) {
+	if casefold(message.Target) != casefold(user.nick) {
+		response.Error(user.nick, protocol.ERR_USERSDONTMATCH)
+		return
+	}
+
+	modes := "+"
+	if user.oper {
+		modes += "o"
 	}
+
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_UMODEIS, user.nick,
+		modes))
 }
 
-func (server *Server) sendMotd(conn *protocol.IrcConnection, target string) {
-	id := config.Config.ServerID
-	conn.Send(fmt.Sprintf(":%s 375 %s :- %s Message of the day - ",
-		id, target, id))
+// modeChangeAllowed checks the privileges needed for a channel mode change.
+// Members may change the modes, operators from outside the channel too.
+// Permanence is for operators only.
+func (server *Server) modeChangeAllowed(user *User, c *channel.Channel,
+	message protocol.ModeMessage, response *protocol.Response) bool {
+	if message.Modes == "" {
+		return true
+	}
+
+	if strings.ContainsRune(message.Modes, 'P') {
+		return server.requireOper(user, response)
+	}
 
-	for _, line := range server.getMotd() {
-		conn.Send(fmt.Sprintf(":%s 372 %s :- %s", id, target, line))
+	if !user.oper && !user.channels[c.Name] {
+		response.Error(user.nick, protocol.ERR_CHANOPRIVSNEEDED, c.Name)
+		return false
+	}
+
+	return true
+}
+
+// handleEmptyChannel stops a channel that reported itself empty. The server
+// may have queued actions to it since, so it is only removed if it is still
+// empty after it has handled them. Its history goes with it, a channel
+// created later under the name starts afresh.
+func (server *Server) handleEmptyChannel(c *channel.Channel) {
+	if server.getChannel(c.Name) != c {
+		return
+	}
+
+	c.Stop()
+	if !c.Empty() || c.Permanent() {
+		c.Start()
+		return
 	}
 
-	conn.Send(fmt.Sprintf(":%s 376 %s :End of /MOTD command.", id, target))
+	delete(server.channels, c.Name)
+	server.history.Delete(c.Name)
+	log.Printf("Removed empty channel: %s", c.Name)
 }
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestPrivateMessageErrors(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+	bob := newTestClient(server, "bob")
+	defer bob.close()
+
+	alice.send(server, "PRIVMSG bob :hi")
+	assert.Equal(t, bob.read(), []string{
+		":alice!~alice@example.org PRIVMSG bob :hi"}, "Message not delivered")
+
+	alice.send(server, "PRIVMSG")
+	alice.send(server, "PRIVMSG bob")
+	alice.send(server, "PRIVMSG carol :hi")
+	alice.send(server, "PRIVMSG #nowhere :hi")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 411 alice :No recipient given",
+		":irc.example 412 alice :No text to send",
+		":irc.example 401 alice carol :No such nick/channel",
+		":irc.example 403 alice #nowhere :No such channel",
+	}, "Undeliverable PRIVMSG not reported")
+
+	bob.send(server, "JOIN #x")
+	bob.read()
+	alice.send(server, "PRIVMSG #x :hi")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 404 alice #x :Cannot send to channel"},
+		"PRIVMSG from outside the channel not reported")
+	assert.Equal(t, len(bob.read()), 0, "PRIVMSG from outside delivered")
+}
+
+func TestNoticeErrors(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+	bob := newTestClient(server, "bob")
+	defer bob.close()
+
+	bob.send(server, "JOIN #x")
+	bob.read()
+
+	alice.send(server, "NOTICE")
+	alice.send(server, "NOTICE bob")
+	alice.send(server, "NOTICE carol :hi")
+	alice.send(server, "NOTICE #nowhere :hi")
+	alice.send(server, "NOTICE #x :hi")
+	assert.Equal(t, len(alice.read()), 0, "NOTICE replied to")
+	assert.Equal(t, len(bob.read()), 0, "NOTICE from outside delivered")
+
+	alice.send(server, "NOTICE bob :hi")
+	assert.Equal(t, bob.read(), []string{
+		":alice!~alice@example.org NOTICE bob :hi"}, "Notice not delivered")
+}
+
+func TestUnknownCommand(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+
+	alice.send(server, "FOO bar")
+	alice.send(server, "")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 421 alice FOO :Unknown command"},
+		"Unknown command not reported once")
+}
+
+func TestChannelModePrivileges(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+	bob := newTestClient(server, "bob")
+	defer bob.close()
+	oper := newTestClient(server, "oper")
+	defer oper.close()
+	oper.user.oper = true
+
+	alice.send(server, "JOIN #x")
+	alice.read()
+
+	alice.send(server, "MODE #x +P")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 481 alice :Permission Denied- You're not an IRC operator"},
+		"Permanence set by a non-operator")
+
+	bob.send(server, "MODE #x +z")
+	assert.Equal(t, bob.read(), []string{
+		":irc.example 482 bob #x :You're not channel operator"},
+		"Modes changed from outside the channel")
+
+	oper.send(server, "MODE #x +P")
+	assert.Equal(t, oper.read(), []string{":oper!~oper@example.org MODE #x +P"},
+		"Operator kept from setting permanence from outside")
+	assert.Equal(t, alice.read(), []string{":oper!~oper@example.org MODE #x +P"},
+		"Members not told of the change")
+}
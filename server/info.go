@@ -0,0 +1,195 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/protocol"
+
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const Version = "channeld-0.1.0"
+
+// commandStats counts the use of a command for STATS m.
+type commandStats struct {
+	count int
+	bytes int
+}
+
+// countCommand records a command the server got from a registered client.
+// Commands the server does not know are counted together, so that clients
+// cannot make up new entries.
+func (server *Server) countCommand(action protocol.ClientAction) {
+	command := action.Command
+	if action.Message.GetType() == protocol.UNKNOWN {
+		command = "UNKNOWN"
+	}
+
+	stats, ok := server.commands[command]
+	if !ok {
+		stats = &commandStats{}
+		server.commands[command] = stats
+	}
+
+	stats.count += 1
+	stats.bytes += action.Size
+}
+
+// numeric builds a reply with its text filled in, for numerics whose text
+// carries values.
+func numeric(n protocol.Numeric, nick, text string,
+	params ...string) protocol.NumericMessage {
+	m := protocol.NewNumeric(n, nick, params...)
+	m.Text = text
+	return m
+}
+
+func (server *Server) handleLusers(user *User, response *protocol.Response) {
+	nick := user.nick
+	users := len(server.users)
+	opers := 0
+	for _, u := range server.users {
+		if u.oper {
+			opers += 1
+		}
+	}
+	unknown := len(server.getConnections()) - users
+	if unknown < 0 {
+		unknown = 0
+	}
+
+	response.SendMessage(numeric(protocol.RPL_LUSERCLIENT, nick,
+		fmt.Sprintf("There are %d users and 0 invisible on 1 servers", users)))
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_LUSEROP, nick,
+		strconv.Itoa(opers)))
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_LUSERUNKNOWN, nick,
+		strconv.Itoa(unknown)))
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_LUSERCHANNELS, nick,
+		strconv.Itoa(len(server.channels))))
+	response.SendMessage(numeric(protocol.RPL_LUSERME, nick,
+		fmt.Sprintf("I have %d clients and 0 servers", users)))
+
+	current, max := strconv.Itoa(users), strconv.Itoa(server.maxUsers)
+	response.SendMessage(numeric(protocol.RPL_LOCALUSERS, nick,
+		fmt.Sprintf("Current local users %s, max %s", current, max),
+		current, max))
+	response.SendMessage(numeric(protocol.RPL_GLOBALUSERS, nick,
+		fmt.Sprintf("Current global users %s, max %s", current, max),
+		current, max))
+}
+
+func (server *Server) handleVersion(user *User, response *protocol.Response) {
+	response.SendMessage(numeric(protocol.RPL_VERSION, user.nick, "",
+		Version, config.Config.ServerID))
+	server.sendISupport(user.nick, response)
+}
+
+func (server *Server) handleTime(user *User, response *protocol.Response) {
+	response.SendMessage(numeric(protocol.RPL_TIME, user.nick,
+		time.Now().Format(time.RFC1123), config.Config.ServerID))
+}
+
+func (server *Server) handleAdmin(user *User, response *protocol.Response) {
+	c := config.Config
+	if c.AdminLocation == "" && c.AdminLocation2 == "" && c.AdminEmail == "" {
+		response.Error(user.nick, protocol.ERR_NOADMININFO, c.ServerID)
+		return
+	}
+
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_ADMINME, user.nick,
+		c.ServerID))
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_ADMINLOC1,
+		user.nick, c.AdminLocation))
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_ADMINLOC2,
+		user.nick, c.AdminLocation2))
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_ADMINEMAIL,
+		user.nick, c.AdminEmail))
+}
+
+func (server *Server) handleInfo(user *User, response *protocol.Response) {
+	lines := append([]string{Version}, config.Config.Info...)
+	lines = append(lines, fmt.Sprintf("On-line since %s",
+		server.started.Format(time.RFC1123)))
+
+	for _, line := range lines {
+		response.SendMessage(protocol.NewNumeric(protocol.RPL_INFO, user.nick,
+			line))
+	}
+
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_ENDOFINFO,
+		user.nick))
+}
+
+// handleStats answers the STATS queries of operators: u for uptime, l for
+// the traffic and lag of every connection, o for the operator accounts, k
+// for bans and m for command usage. There are no server bans, so k is
+// always empty.
+func (server *Server) handleStats(user *User, message protocol.StatsMessage,
+	response *protocol.Response) {
+	if !server.requireOper(user, response) {
+		return
+	}
+
+	nick := user.nick
+	query := message.Query
+	if len(query) > 1 {
+		query = query[:1]
+	}
+
+	switch query {
+	case "u":
+		up := time.Since(server.started)
+		days := int(up.Hours()) / 24
+		response.SendMessage(numeric(protocol.RPL_STATSUPTIME, nick,
+			fmt.Sprintf("Server Up %d days %d:%02d:%02d", days,
+				int(up.Hours())%24, int(up.Minutes())%60,
+				int(up.Seconds())%60)))
+	case "l":
+		for _, conn := range server.getConnections() {
+			name := conn.NetConn().RemoteAddr().String()
+			if u := server.getUserByConn(conn); u != nil {
+				name = u.hostmask()
+			}
+
+			stats := conn.Stats()
+			response.SendMessage(numeric(protocol.RPL_STATSLINKINFO, nick,
+				strconv.Itoa(int(time.Since(stats.Connected).Seconds())),
+				name, strconv.Itoa(stats.SendQ),
+				strconv.FormatUint(stats.MessagesSent, 10),
+				strconv.FormatUint(stats.BytesSent/1024, 10),
+				strconv.FormatUint(stats.MessagesReceived, 10),
+				strconv.FormatUint(stats.BytesReceived/1024, 10),
+				strconv.FormatInt(stats.Lag.Milliseconds(), 10)))
+		}
+	case "o":
+		for _, oper := range config.Config.Opers {
+			hosts := oper.Hosts
+			if len(hosts) == 0 {
+				hosts = []string{"*@*"}
+			}
+
+			for _, host := range hosts {
+				response.SendMessage(numeric(protocol.RPL_STATSOLINE, nick,
+					"", "O", host, "*", oper.Name))
+			}
+		}
+	case "m":
+		commands := []string{}
+		for command := range server.commands {
+			commands = append(commands, command)
+		}
+		sort.Strings(commands)
+
+		for _, command := range commands {
+			stats := server.commands[command]
+			response.SendMessage(numeric(protocol.RPL_STATSCOMMANDS, nick, "",
+				command, strconv.Itoa(stats.count), strconv.Itoa(stats.bytes),
+				"0"))
+		}
+	}
+
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_ENDOFSTATS, nick,
+		query))
+}
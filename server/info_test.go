@@ -0,0 +1,154 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"strings"
+	"testing"
+)
+
+func TestLusers(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	bob := newTestClient(server, "bob")
+	defer bob.close()
+	bob.user.oper = true
+
+	bob.send(server, "JOIN #x")
+	bob.read()
+	alice.send(server, "QUIT")
+	alice.close()
+
+	bob.send(server, "LUSERS")
+	assert.Equal(t, bob.read(), []string{
+		":irc.example 251 bob :There are 1 users and 0 invisible on 1 servers",
+		":irc.example 252 bob 1 :operator(s) online",
+		":irc.example 253 bob 1 :unknown connection(s)",
+		":irc.example 254 bob 1 :channels formed",
+		":irc.example 255 bob :I have 1 clients and 0 servers",
+		":irc.example 265 bob 1 2 :Current local users 1, max 2",
+		":irc.example 266 bob 1 2 :Current global users 1, max 2",
+	}, "Counts wrong")
+}
+
+func TestVersionAndTime(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+
+	alice.send(server, "VERSION")
+	lines := alice.read()
+	assert.Equal(t, len(lines), 2, "VERSION not answered")
+	assert.Equal(t, lines[0], ":irc.example 351 alice "+Version+
+		" irc.example", "Version not sent")
+	assert.True(t, strings.HasPrefix(lines[1], ":irc.example 005 alice "),
+		"ISUPPORT not sent")
+
+	alice.send(server, "TIME")
+	lines = alice.read()
+	assert.Equal(t, len(lines), 1, "TIME not answered")
+	assert.True(t, strings.HasPrefix(lines[0],
+		":irc.example 391 alice irc.example :"), "Time not sent")
+}
+
+func TestAdminAndInfo(t *testing.T) {
+	defer func(location, email string, info []string) {
+		config.Config.AdminLocation = location
+		config.Config.AdminEmail = email
+		config.Config.Info = info
+	}(config.Config.AdminLocation, config.Config.AdminEmail,
+		config.Config.Info)
+
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+
+	alice.send(server, "ADMIN")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 423 alice irc.example :No administrative info available"},
+		"Missing admin info not reported")
+
+	config.Config.AdminLocation = "Helsinki"
+	config.Config.AdminEmail = "admin@example.org"
+	config.Config.Info = []string{"A test server"}
+
+	alice.send(server, "ADMIN")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 256 alice irc.example :Administrative info",
+		":irc.example 257 alice :Helsinki",
+		":irc.example 258 alice :",
+		":irc.example 259 alice :admin@example.org",
+	}, "Admin info not sent")
+
+	alice.send(server, "INFO")
+	lines := alice.read()
+	assert.Equal(t, len(lines), 4, "INFO not answered")
+	assert.Equal(t, lines[:2], []string{
+		":irc.example 371 alice :" + Version,
+		":irc.example 371 alice :A test server",
+	}, "Info lines not sent")
+	assert.Equal(t, lines[3], ":irc.example 374 alice :End of INFO list",
+		"Info not ended")
+}
+
+func TestStats(t *testing.T) {
+	defer func(opers []config.Oper) { config.Config.Opers = opers }(
+		config.Config.Opers)
+	config.Config.Opers = []config.Oper{{"admin", "pw", nil}}
+
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+
+	alice.send(server, "STATS u")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 481 alice :Permission Denied- You're not an IRC operator"},
+		"Statistics shown to a non-operator")
+	alice.user.oper = true
+
+	alice.send(server, "STATS u")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 242 alice :Server Up 0 days 0:00:00",
+		":irc.example 219 alice u :End of /STATS report",
+	}, "Uptime not sent")
+
+	alice.send(server, "STATS l")
+	lines := alice.read()
+	assert.Equal(t, len(lines), 2, "Connections not listed")
+	assert.True(t, strings.HasPrefix(lines[0],
+		":irc.example 211 alice alice!~alice@example.org "),
+		"Connection not listed")
+
+	alice.send(server, "STATS o")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 243 alice O *@* * admin",
+		":irc.example 219 alice o :End of /STATS report",
+	}, "Operators not listed")
+
+	alice.send(server, "STATS k")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 219 alice k :End of /STATS report",
+	}, "Bans made up")
+}
+
+func TestStatsCommands(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+	alice.user.oper = true
+
+	alice.send(server, "USER a b c :d")
+	alice.send(server, "foo")
+	alice.send(server, "BAR baz")
+	alice.send(server, "time")
+	alice.read()
+
+	alice.send(server, "STATS m")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 212 alice STATS 1 9 0",
+		":irc.example 212 alice UNKNOWN 3 20 0",
+		":irc.example 212 alice USER 1 15 0",
+		":irc.example 219 alice m :End of /STATS report",
+	}, "Commands not counted")
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/protocol"
+
+	"fmt"
+	"strings"
+)
+
+// isupport lists the RPL_ISUPPORT tokens describing the server.
+func isupport() []string {
+	return []string{
+		"CASEMAPPING=ascii",
+		"CHANTYPES=#!",
+		"CHANMODES=,,,P",
+		fmt.Sprintf("CHATHISTORY=%d", config.Config.HistoryQueryLimit),
+		fmt.Sprintf("MONITOR=%d", config.Config.MonitorLimit),
+		fmt.Sprintf("USERLEN=%d", config.Config.UserLen),
+	}
+}
+
+// sendISupport sends the ISUPPORT tokens, at most 13 per line.
+func (server *Server) sendISupport(nick string,
+	response *protocol.Response) {
+	tokens := isupport()
+	for len(tokens) > 0 {
+		n := len(tokens)
+		if n > 13 {
+			n = 13
+		}
+
+		response.SendMessage(protocol.NewNumeric(protocol.RPL_ISUPPORT, nick,
+			strings.Join(tokens[:n], " ")))
+		tokens = tokens[n:]
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+
+	"net"
+	"sync"
+)
+
+// connectionLimiter counts accepted connections globally, per class and
+// per address so that limits can be enforced before the handshake starts.
+type connectionLimiter struct {
+	mutex   sync.Mutex
+	total   int
+	classes map[*config.Class]int
+	hosts   map[string]int
+}
+
+func newConnectionLimiter() *connectionLimiter {
+	l := new(connectionLimiter)
+	l.classes = make(map[*config.Class]int)
+	l.hosts = make(map[string]int)
+
+	return l
+}
+
+// hostKey groups IPv6 addresses by their /64 as a single host usually has
+// the whole prefix to itself.
+func hostKey(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+
+	if ip.To4() != nil {
+		return ip.String()
+	}
+
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// admit reserves a slot for a new connection, returning the reason for
+// the rejection if there is none left.
+func (l *connectionLimiter) admit(ip net.IP, class *config.Class) string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	key := hostKey(ip)
+
+	if config.Config.MaxClients > 0 && l.total >= config.Config.MaxClients {
+		return "Server is full"
+	}
+
+	if class.MaxClients > 0 && l.classes[class] >= class.MaxClients {
+		return "Too many connections in your connection class"
+	}
+
+	if class.MaxPerIP > 0 && key != "" && l.hosts[key] >= class.MaxPerIP {
+		return "Too many connections from your host"
+	}
+
+	l.total += 1
+	l.classes[class] += 1
+	if key != "" {
+		l.hosts[key] += 1
+	}
+
+	return ""
+}
+
+func (l *connectionLimiter) release(ip net.IP, class *config.Class) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	key := hostKey(ip)
+
+	l.total -= 1
+	l.classes[class] -= 1
+	if key != "" {
+		l.hosts[key] -= 1
+		if l.hosts[key] == 0 {
+			delete(l.hosts, key)
+		}
+	}
+}
+
+func remoteIP(conn net.Conn) net.IP {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"net"
+	"testing"
+)
+
+func TestConnectionLimits(t *testing.T) {
+	defer func(max int) { config.Config.MaxClients = max }(
+		config.Config.MaxClients)
+	config.Config.MaxClients = 0
+
+	l := newConnectionLimiter()
+	class := &config.Class{Name: "c", MaxPerIP: 2}
+	a := net.ParseIP("192.0.2.1")
+	b := net.ParseIP("192.0.2.2")
+
+	assert.Equal(t, l.admit(a, class), "", "First connection rejected")
+	assert.Equal(t, l.admit(a, class), "", "Second connection rejected")
+	assert.Equal(t, l.admit(a, class), "Too many connections from your host",
+		"Connection over MaxPerIP admitted")
+	assert.Equal(t, l.admit(b, class), "", "Other address rejected")
+
+	l.release(a, class)
+	assert.Equal(t, l.admit(a, class), "", "Released slot not reused")
+}
+
+func TestConnectionLimitsIPv6(t *testing.T) {
+	l := newConnectionLimiter()
+	class := &config.Class{Name: "c", MaxPerIP: 1}
+
+	assert.Equal(t, l.admit(net.ParseIP("2001:db8::1"), class), "",
+		"First connection rejected")
+	assert.Equal(t, l.admit(net.ParseIP("2001:db8::ffff:2"), class),
+		"Too many connections from your host",
+		"Connection from the same /64 admitted")
+	assert.Equal(t, l.admit(net.ParseIP("2001:db8:0:1::1"), class), "",
+		"Connection from another /64 rejected")
+}
+
+func TestClassAndGlobalLimits(t *testing.T) {
+	defer func(max int) { config.Config.MaxClients = max }(
+		config.Config.MaxClients)
+	config.Config.MaxClients = 3
+
+	l := newConnectionLimiter()
+	small := &config.Class{Name: "small", MaxClients: 1}
+	other := &config.Class{Name: "other"}
+
+	assert.Equal(t, l.admit(net.ParseIP("192.0.2.1"), small), "",
+		"First connection rejected")
+	assert.Equal(t, l.admit(net.ParseIP("192.0.2.2"), small),
+		"Too many connections in your connection class",
+		"Connection over the class MaxClients admitted")
+
+	assert.Equal(t, l.admit(net.ParseIP("192.0.2.3"), other), "",
+		"Connection in another class rejected")
+	assert.Equal(t, l.admit(nil, other), "", "Unix socket rejected")
+	assert.Equal(t, l.admit(net.ParseIP("192.0.2.4"), other),
+		"Server is full", "Connection over MaxClients admitted")
+}
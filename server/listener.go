@@ -0,0 +1,134 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/proxyproto"
+	"github.com/jukeks/channeld/websocket"
+
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const proxyHeaderTimeout = 10 * time.Second
+
+// webSocketHeaderTimeout bounds the time a browser has to send the headers
+// of its upgrade request.
+const webSocketHeaderTimeout = 10 * time.Second
+
+// activeListener is an open listener. raw is the socket itself, listener
+// the same with the PROXY protocol and TLS layered on top as configured.
+type activeListener struct {
+	config   config.Listener
+	raw      net.Listener
+	listener net.Listener
+}
+
+// listen opens the listener, or takes over the one left by the previous
+// process when restarting.
+func listen(l config.Listener, state *restartState) (*activeListener,
+	error) {
+	raw, err := inheritListener(l, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw == nil {
+		if l.Network == "unix" {
+			// a socket left behind by a previous run would make Listen fail
+			os.Remove(l.Address)
+		}
+
+		raw, err = net.Listen(l.Network, l.Address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener := raw
+
+	// the PROXY header precedes the TLS handshake
+	if l.ProxyProtocol {
+		listener = proxyproto.NewListener(listener, l.TrustedProxies,
+			proxyHeaderTimeout)
+	}
+
+	if l.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(l.TLSCert, l.TLSKey)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+
+		listener = tls.NewListener(listener,
+			&tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	return &activeListener{l, raw, listener}, nil
+}
+
+func (server *Server) acceptLoop(listener net.Listener, l config.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// accepting is paused during a restart, which may fail
+			if !server.restarting.Load() {
+				log.Printf("Error accepting on %s %s: %v", l.Network,
+					l.Address, err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		server.accept(conn, l)
+	}
+}
+
+// serveWebSocket upgrades the HTTP requests of a WebSocket listener and
+// passes the connections on like any other.
+func (server *Server) serveWebSocket(listener net.Listener,
+	l config.Listener) {
+	options := websocket.Options{Origins: l.Origins,
+		TrustedProxies: l.TrustedProxies}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r, options)
+		if err != nil {
+			log.Printf("WebSocket upgrade from %s failed: %v", r.RemoteAddr,
+				err)
+			return
+		}
+
+		server.accept(conn, l)
+	}
+
+	httpServer := &http.Server{Handler: http.HandlerFunc(handler),
+		ReadHeaderTimeout: webSocketHeaderTimeout}
+	err := httpServer.Serve(listener)
+	if !errors.Is(err, net.ErrClosed) {
+		log.Printf("Error serving WebSocket on %s: %v", l.Address, err)
+	}
+}
+
+// listenerClass is the class configured for the listener, or the one
+// matching the client's address.
+func listenerClass(l config.Listener, ip net.IP) *config.Class {
+	if l.Class != "" {
+		if class := config.Config.GetClass(l.Class); class != nil {
+			return class
+		}
+
+		log.Printf("Listener %s %s has unknown class %s", l.Network,
+			l.Address, l.Class)
+	}
+
+	return config.Config.ClassFor(ip)
+}
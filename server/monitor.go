@@ -0,0 +1,141 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/protocol"
+
+	"strconv"
+	"strings"
+)
+
+// sendNickList sends a numeric listing nicks, split over as many lines as
+// needed.
+func sendNickList(send func(protocol.IrcMessage), numeric protocol.Numeric,
+	nick string, items []string) {
+	prefix := len(protocol.NewNumeric(numeric, nick, "").Serialize())
+
+	line := ""
+	for _, item := range items {
+		if line != "" && prefix+len(line)+len(item)+1 > 510 {
+			send(protocol.NewNumeric(numeric, nick, line))
+			line = ""
+		}
+
+		if line != "" {
+			line += ","
+		}
+		line += item
+	}
+
+	if line != "" {
+		send(protocol.NewNumeric(numeric, nick, line))
+	}
+}
+
+// monitoringNicks lists the user's MONITOR list as given.
+func (user *User) monitoringNicks() []string {
+	nicks := []string{}
+	for _, nick := range user.monitoring {
+		nicks = append(nicks, nick)
+	}
+
+	return nicks
+}
+
+// monitor adds a nick to the user's MONITOR list.
+func (server *Server) monitor(user *User, nick string) {
+	key := casefold(nick)
+	user.monitoring[key] = nick
+
+	if server.monitors[key] == nil {
+		server.monitors[key] = make(map[*User]bool)
+	}
+	server.monitors[key][user] = true
+}
+
+func (server *Server) unmonitor(user *User, key string) {
+	delete(user.monitoring, key)
+
+	delete(server.monitors[key], user)
+	if len(server.monitors[key]) == 0 {
+		delete(server.monitors, key)
+	}
+}
+
+// clearMonitors empties the user's MONITOR list.
+func (server *Server) clearMonitors(user *User) {
+	for key := range user.monitoring {
+		server.unmonitor(user, key)
+	}
+}
+
+// notifyMonitors tells the users monitoring the user's nick that it came
+// online or went offline.
+func (server *Server) notifyMonitors(user *User, online bool) {
+	for watcher := range server.monitors[casefold(user.nick)] {
+		if online {
+			sendNickList(watcher.conn.SendMessage, protocol.RPL_MONONLINE,
+				watcher.nick, []string{user.hostmask()})
+		} else {
+			sendNickList(watcher.conn.SendMessage, protocol.RPL_MONOFFLINE,
+				watcher.nick, []string{user.nick})
+		}
+	}
+}
+
+// sendMonitorStatus tells which of the nicks are online.
+func (server *Server) sendMonitorStatus(user *User, nicks []string,
+	response *protocol.Response) {
+	online := []string{}
+	offline := []string{}
+	for _, nick := range nicks {
+		if other := server.getUserByName(nick); other != nil {
+			online = append(online, other.hostmask())
+		} else {
+			offline = append(offline, nick)
+		}
+	}
+
+	sendNickList(response.SendMessage, protocol.RPL_MONONLINE, user.nick,
+		online)
+	sendNickList(response.SendMessage, protocol.RPL_MONOFFLINE, user.nick,
+		offline)
+}
+
+func (server *Server) handleMonitor(user *User,
+	message protocol.MonitorMessage, response *protocol.Response) {
+	switch message.Subcommand {
+	case "+":
+		added := []string{}
+		for i, nick := range message.Targets {
+			if _, ok := user.monitoring[casefold(nick)]; ok {
+				continue
+			}
+
+			if len(user.monitoring) >= config.Config.MonitorLimit {
+				response.Error(user.nick, protocol.ERR_MONLISTFULL,
+					strconv.Itoa(config.Config.MonitorLimit),
+					strings.Join(message.Targets[i:], ","))
+				break
+			}
+
+			server.monitor(user, nick)
+			added = append(added, nick)
+		}
+
+		server.sendMonitorStatus(user, added, response)
+	case "-":
+		for _, nick := range message.Targets {
+			server.unmonitor(user, casefold(nick))
+		}
+	case "C":
+		server.clearMonitors(user)
+	case "L":
+		sendNickList(response.SendMessage, protocol.RPL_MONLIST, user.nick,
+			user.monitoringNicks())
+		response.SendMessage(protocol.NewNumeric(protocol.RPL_ENDOFMONLIST,
+			user.nick))
+	case "S":
+		server.sendMonitorStatus(user, user.monitoringNicks(), response)
+	}
+}
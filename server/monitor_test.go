@@ -0,0 +1,95 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestMonitor(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+
+	alice.send(server, "MONITOR + bob,Carol")
+	assert.Equal(t, alice.read(), []string{":irc.example 731 alice :bob,Carol"},
+		"Offline nicks not reported")
+
+	alice.send(server, "MONITOR + BOB")
+	assert.Equal(t, len(alice.read()), 0, "Nick added twice")
+
+	alice.send(server, "MONITOR L")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 732 alice :bob,Carol",
+		":irc.example 733 alice :End of MONITOR list",
+	}, "List not sent")
+
+	bob := newTestClient(server, "bob")
+	defer bob.close()
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 730 alice :bob!~bob@example.org"},
+		"Connecting user not reported")
+
+	alice.send(server, "MONITOR S")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 730 alice :bob!~bob@example.org",
+		":irc.example 731 alice :Carol",
+	}, "Status not sent")
+
+	alice.send(server, "MONITOR - BOB")
+	alice.send(server, "MONITOR L")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 732 alice :Carol",
+		":irc.example 733 alice :End of MONITOR list",
+	}, "Nick not removed")
+
+	alice.send(server, "MONITOR C")
+	alice.send(server, "MONITOR L")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 733 alice :End of MONITOR list"}, "List not cleared")
+
+	bob.send(server, "QUIT :bye")
+	assert.Equal(t, len(alice.read()), 0, "Removed nick still monitored")
+}
+
+func TestMonitorLimit(t *testing.T) {
+	defer func(limit int) { config.Config.MonitorLimit = limit }(
+		config.Config.MonitorLimit)
+	config.Config.MonitorLimit = 2
+
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+
+	alice.send(server, "MONITOR + a,b,c,d")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 734 alice 2 c,d :Monitor list is full.",
+		":irc.example 731 alice :a,b",
+	}, "Limit not enforced")
+	assert.Equal(t, len(alice.user.monitoring), 2, "Nicks over the limit kept")
+}
+
+func TestMonitorNotifications(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+	bob := newTestClient(server, "bob")
+	defer bob.close()
+
+	alice.send(server, "MONITOR + bob,robert")
+	alice.read()
+
+	bob.send(server, "NICK Bob")
+	assert.Equal(t, len(alice.read()), 0, "Case change reported")
+
+	bob.send(server, "NICK robert")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 731 alice :Bob",
+		":irc.example 730 alice :robert!~bob@example.org",
+	}, "Nick change not reported")
+
+	bob.send(server, "QUIT :bye")
+	assert.Equal(t, alice.read(), []string{":irc.example 731 alice :robert"},
+		"Quit not reported")
+}
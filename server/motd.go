@@ -0,0 +1,90 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/protocol"
+
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// loadMotds reads the message of the day files of the server and of every
+// class. Files that cannot be read are left out, so their clients get
+// ERR_NOMOTD.
+func (server *Server) loadMotds() {
+	paths := []string{config.Config.MOTD}
+	for _, class := range config.Config.Classes {
+		paths = append(paths, class.MOTD)
+	}
+
+	motds := map[string][]string{}
+	for _, path := range paths {
+		if _, ok := motds[path]; ok || path == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Reading MOTD failed: %v", err)
+			continue
+		}
+
+		text := strings.TrimRight(strings.ReplaceAll(string(data), "\r", ""),
+			"\n")
+		motds[path] = strings.Split(text, "\n")
+	}
+
+	server.motds = motds
+}
+
+func (server *Server) sendMotd(class *config.Class, nick string,
+	response *protocol.Response) {
+	lines, ok := server.motds[class.GetMOTD()]
+	if !ok {
+		response.Error(nick, protocol.ERR_NOMOTD)
+		return
+	}
+
+	start := protocol.NewNumeric(protocol.RPL_MOTDSTART, nick)
+	start.Text = fmt.Sprintf("- %s Message of the day - ",
+		config.Config.ServerID)
+	response.SendMessage(start)
+
+	for _, line := range lines {
+		motd := protocol.NewNumeric(protocol.RPL_MOTD, nick)
+		motd.Text = "- " + line
+		response.SendMessage(motd)
+	}
+
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_ENDOFMOTD, nick))
+}
+
+// Rehash reloads what the server reads from files, as if an operator had
+// sent REHASH.
+func (server *Server) Rehash() {
+	server.rehashRequests <- true
+}
+
+func (server *Server) rehash() {
+	log.Printf("Rehashing")
+	server.loadMotds()
+}
+
+func (server *Server) handleRehash(user *User, response *protocol.Response) {
+	if !server.requireOper(user, response) {
+		return
+	}
+
+	// the configuration is compiled in, the MOTD is the file reread
+	file := config.Config.MOTD
+	if file == "" {
+		file = "*"
+	}
+
+	log.Printf("REHASH from %s", user.hostmask())
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_REHASHING,
+		user.nick, file))
+	server.rehash()
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/protocol"
+	"github.com/stretchr/testify/assert"
+
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMotd(t *testing.T) {
+	defer func(motd string) { config.Config.MOTD = motd }(config.Config.MOTD)
+	config.Config.MOTD = ""
+
+	server := newTestServer()
+	server.loadMotds()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+
+	alice.send(server, "MOTD")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 422 alice :MOTD File is missing"}, "Missing MOTD sent")
+
+	path := filepath.Join(t.TempDir(), "motd.txt")
+	os.WriteFile(path, []byte("Welcome\r\n\r\nBe nice\n"), 0644)
+	config.Config.MOTD = path
+	server.loadMotds()
+
+	alice.send(server, "MOTD")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 375 alice :- irc.example Message of the day - ",
+		":irc.example 372 alice :- Welcome",
+		":irc.example 372 alice :- ",
+		":irc.example 372 alice :- Be nice",
+		":irc.example 376 alice :End of /MOTD command.",
+	}, "MOTD not sent from the file")
+}
+
+func TestClassMotd(t *testing.T) {
+	defer func(motd string) { config.Config.MOTD = motd }(config.Config.MOTD)
+	dir := t.TempDir()
+	config.Config.MOTD = filepath.Join(dir, "motd.txt")
+	os.WriteFile(config.Config.MOTD, []byte("Server"), 0644)
+
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+
+	class := *alice.user.conn.Class()
+	class.MOTD = filepath.Join(dir, "class.txt")
+	os.WriteFile(class.MOTD, []byte("Class"), 0644)
+	config.Config.Classes = append(config.Config.Classes, &class)
+	defer func() {
+		config.Config.Classes = config.Config.Classes[:len(
+			config.Config.Classes)-1]
+	}()
+	server.loadMotds()
+
+	server.sendMotd(&class, "alice", alice.user.conn.NewResponse(
+		protocol.Metadata{}))
+	lines := alice.read()
+	assert.Equal(t, len(lines), 3, "MOTD not sent")
+	assert.Equal(t, lines[1], ":irc.example 372 alice :- Class",
+		"Class MOTD not preferred")
+
+	alice.send(server, "MOTD")
+	lines = alice.read()
+	assert.Equal(t, len(lines), 3, "MOTD not sent")
+	assert.Equal(t, lines[1], ":irc.example 372 alice :- Server",
+		"Server MOTD not used for other classes")
+}
+
+func TestRehash(t *testing.T) {
+	defer func(motd string) { config.Config.MOTD = motd }(config.Config.MOTD)
+	config.Config.MOTD = ""
+
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+
+	alice.send(server, "REHASH")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 481 alice :Permission Denied- You're not an IRC operator"},
+		"Rehash by a non-operator")
+
+	alice.user.oper = true
+	alice.send(server, "REHASH")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 382 alice * :Rehashing"}, "Rehash not confirmed")
+
+	config.Config.MOTD = filepath.Join(t.TempDir(), "motd.txt")
+	os.WriteFile(config.Config.MOTD, []byte("Old"), 0644)
+	alice.send(server, "REHASH")
+	assert.Equal(t, alice.read(), []string{
+		":irc.example 382 alice " + config.Config.MOTD + " :Rehashing"},
+		"Rehash not confirmed with the file")
+
+	os.WriteFile(config.Config.MOTD, []byte("New"), 0644)
+	alice.send(server, "REHASH")
+	alice.send(server, "MOTD")
+	lines := alice.read()
+	assert.Equal(t, len(lines), 4, "MOTD not sent")
+	assert.Equal(t, lines[2], ":irc.example 372 alice :- New",
+		"MOTD not reread")
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/protocol"
+
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+)
+
+func operHostAllowed(oper *config.Oper, user *User) bool {
+	if len(oper.Hosts) == 0 {
+		return true
+	}
+
+	for _, mask := range oper.Hosts {
+		if protocol.MatchMask(mask, user.username+"@"+user.hostname) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (server *Server) handleOper(user *User, message protocol.OperMessage,
+	response *protocol.Response) {
+	oper := config.Config.GetOper(message.Name)
+
+	if oper == nil || subtle.ConstantTimeCompare([]byte(oper.Password),
+		[]byte(message.Password)) != 1 {
+		log.Printf("%s failed OPER as %s", user.nick, message.Name)
+		response.Error(user.nick, protocol.ERR_PASSWDMISMATCH)
+		return
+	}
+
+	if !operHostAllowed(oper, user) {
+		log.Printf("%s tried OPER as %s from a wrong host", user.nick,
+			message.Name)
+		response.Error(user.nick, protocol.ERR_NOOPERHOST)
+		return
+	}
+
+	log.Printf("%s is now an operator as %s", user.nick, message.Name)
+	user.oper = true
+	user.conn.SetFloodExempt(true)
+
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_YOUREOPER,
+		user.nick))
+	response.Send(fmt.Sprintf(":%s MODE %s :+o", user.nick, user.nick))
+}
+
+// requireOper tells non-operators off, returning whether the user may
+// proceed.
+func (server *Server) requireOper(user *User,
+	response *protocol.Response) bool {
+	if user.oper {
+		return true
+	}
+
+	response.Error(user.nick, protocol.ERR_NOPRIVILEGES)
+	return false
+}
+
+func (server *Server) handleDie(user *User, response *protocol.Response) {
+	if !server.requireOper(user, response) || server.restarting.Load() {
+		return
+	}
+
+	log.Printf("DIE from %s", user.hostmask())
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		config.Config.ShutdownTimeout)
+	defer cancel()
+
+	server.shutdown(ctx)
+}
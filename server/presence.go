@@ -0,0 +1,84 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/protocol"
+
+	"fmt"
+	"strings"
+)
+
+// userhostLimit is the number of nicks a USERHOST query answers for.
+const userhostLimit = 5
+
+// handleIson tells which of the nicks are online, spelled as the users
+// spell them.
+func (server *Server) handleIson(user *User, message protocol.IsonMessage,
+	response *protocol.Response) {
+	if len(message.Nicks) == 0 {
+		response.Error(user.nick, protocol.ERR_NEEDMOREPARAMS, "ISON")
+		return
+	}
+
+	online := []string{}
+	for _, nick := range message.Nicks {
+		if other := server.getUserByName(nick); other != nil {
+			online = append(online, other.nick)
+		}
+	}
+
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_ISON, user.nick,
+		strings.Join(online, " ")))
+}
+
+// userhostReply is nick[*]=(+|-)user@host, with the '*' for operators and
+// '-' for users who are away.
+func userhostReply(user *User) string {
+	oper := ""
+	if user.oper {
+		oper = "*"
+	}
+
+	away := "+"
+	if user.away != "" {
+		away = "-"
+	}
+
+	return fmt.Sprintf("%s%s=%s%s@%s", user.nick, oper, away, user.username,
+		user.hostname)
+}
+
+func (server *Server) handleUserhost(user *User,
+	message protocol.UserhostMessage, response *protocol.Response) {
+	if len(message.Nicks) == 0 {
+		response.Error(user.nick, protocol.ERR_NEEDMOREPARAMS, "USERHOST")
+		return
+	}
+
+	nicks := message.Nicks
+	if len(nicks) > userhostLimit {
+		nicks = nicks[:userhostLimit]
+	}
+
+	replies := []string{}
+	for _, nick := range nicks {
+		if other := server.getUserByName(nick); other != nil {
+			replies = append(replies, userhostReply(other))
+		}
+	}
+
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_USERHOST, user.nick,
+		strings.Join(replies, " ")))
+}
+
+func (server *Server) handleAway(user *User, message protocol.AwayMessage,
+	response *protocol.Response) {
+	user.away = message.Message
+
+	if user.away == "" {
+		response.SendMessage(protocol.NewNumeric(protocol.RPL_UNAWAY,
+			user.nick))
+		return
+	}
+
+	response.SendMessage(protocol.NewNumeric(protocol.RPL_NOWAWAY, user.nick))
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/channel"
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/history"
+	"github.com/jukeks/channeld/protocol"
+	"github.com/jukeks/channeld/proxyproto"
+	"github.com/jukeks/channeld/store"
+
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// restartStateEnv names the file the state is passed in to the new
+// process. The sockets themselves are inherited as file descriptors.
+const restartStateEnv = "CHANNELD_RESTART_STATE"
+
+type restartState struct {
+	ServerID    string
+	Listeners   []listenerState
+	Connections []connectionState
+	Channels    []channel.ChannelState
+
+	// History is the message history, if it is kept in memory. Private
+	// conversations are kept under user ids, which is why those are
+	// handed over too.
+	History map[string][]history.Entry
+}
+
+type listenerState struct {
+	Network string
+	Address string
+	Fd      uintptr
+}
+
+type connectionState struct {
+	Fd     uintptr
+	Remote string
+	Class  string
+	Input  []byte
+	Output []string
+
+	ID       string
+	Nick     string
+	Username string
+	Realname string
+	Hostname string
+	Account  string
+	Oper     bool
+	Away     string
+	Caps     protocol.Capability
+
+	Monitoring []string
+}
+
+// remoteConn is a resumed connection whose address was given by a proxy.
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *remoteConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// handoverConn finds the socket under a client connection along with the
+// address of the client and any input buffered on the way. TLS and
+// WebSocket sessions cannot be moved to another process.
+func handoverConn(conn net.Conn) (net.Conn, string, []byte, bool) {
+	switch c := conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return conn, "", nil, true
+	case *proxyproto.Conn:
+		return c.NetConn(), c.RemoteAddr().String(), c.Buffered(), true
+	case *remoteConn:
+		return c.Conn, c.remote.String(), nil, true
+	default:
+		return nil, "", nil, false
+	}
+}
+
+func (server *Server) handleRestart(user *User, response *protocol.Response) {
+	if !server.requireOper(user, response) || server.restarting.Load() {
+		return
+	}
+
+	log.Printf("RESTART from %s", user.hostmask())
+
+	err := server.restart()
+	if err == nil {
+		return
+	}
+
+	log.Printf("Restart failed: %v", err)
+	notice := fmt.Sprintf(":%s NOTICE %s :Restart failed: %v",
+		config.Config.ServerID, user.nick, err)
+
+	// a restart failing late has taken everyone back on new connections
+	if server.getUserByConn(response.Conn()) == user {
+		response.Send(notice)
+	} else if current := server.getUserByName(user.nick); current != nil {
+		current.conn.Send(notice)
+	}
+}
+
+// restart replaces the running binary with the one on disk. Listeners and
+// the sockets of registered clients are inherited by the new process along
+// with the state of the users and channels, so clients stay connected. If
+// the new process cannot be started, the clients handed over are taken
+// back and served on as before, and the error is returned.
+func (server *Server) restart() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(executable); err != nil {
+		return err
+	}
+
+	state := restartState{ServerID: config.Config.ServerID}
+	listenerFiles := []*os.File{}
+
+	for _, active := range server.listeners {
+		file, err := inheritableFile(active.raw)
+		if err != nil {
+			for _, f := range listenerFiles {
+				f.Close()
+			}
+
+			return err
+		}
+
+		listenerFiles = append(listenerFiles, file)
+		state.Listeners = append(state.Listeners, listenerState{
+			active.config.Network, active.config.Address, file.Fd()})
+	}
+
+	server.restarting.Store(true)
+	for _, active := range server.listeners {
+		if l, ok := active.raw.(interface{ SetDeadline(time.Time) error }); ok {
+			l.SetDeadline(time.Now())
+		}
+	}
+
+	// lines already read from the clients are handled before anything is
+	// handed over, and the channels finish what they were sent
+	readers := []<-chan bool{}
+	for _, conn := range server.getConnections() {
+		if server.getUserByConn(conn) != nil {
+			readers = append(readers, conn.StopReading())
+		}
+	}
+	server.drainIncoming(readers)
+
+	for _, c := range server.channels {
+		c.Stop()
+		state.Channels = append(state.Channels, c.State())
+	}
+
+	if memory, ok := server.history.(*history.MemoryStore); ok {
+		state.History = memory.Export()
+	}
+
+	deadline := time.Now().Add(config.Config.ShutdownTimeout)
+	var wg sync.WaitGroup
+	dropped := map[*protocol.IrcConnection]bool{}
+
+	// files are the inherited sockets of the connections in the state,
+	// sockets the ones they were duplicated from
+	files := []*os.File{}
+	sockets := []net.Conn{}
+
+	for _, conn := range server.getConnections() {
+		user := server.getUserByConn(conn)
+
+		raw, remote, proxied, ok := handoverConn(conn.NetConn())
+		var file *os.File
+		if ok && user != nil {
+			file, err = inheritableFile(raw)
+			ok = err == nil
+		}
+
+		if user == nil || !ok {
+			dropped[conn] = true
+			wg.Add(1)
+			go func(conn *protocol.IrcConnection) {
+				defer wg.Done()
+				conn.Quit("Server restarting, please reconnect", deadline)
+			}(conn)
+			continue
+		}
+
+		input, output, ok := conn.Detach()
+		if !ok {
+			// closed while the others were being handed over
+			file.Close()
+			dropped[conn] = true
+			continue
+		}
+
+		files = append(files, file)
+		sockets = append(sockets, raw)
+		state.Connections = append(state.Connections, connectionState{
+			file.Fd(), remote, conn.Class().Name, append(input, proxied...),
+			output, user.id, user.nick, user.username, user.realname, user.hostname,
+			user.account, user.oper, user.away, conn.Capabilities(),
+			user.monitoringNicks()})
+	}
+	wg.Wait()
+
+	path, err := writeRestartState(state)
+	if err == nil {
+		log.Printf("Restarting with %d connections, %d dropped",
+			len(state.Connections), len(dropped))
+
+		if err := server.store.Close(); err != nil {
+			log.Printf("Closing channel store failed: %v", err)
+		}
+
+		env := append(os.Environ(), restartStateEnv+"="+path)
+		err = execSelf(executable, env)
+
+		// exec only returns on failure
+		os.Remove(path)
+		server.store, _ = store.OpenChannelStore(config.Config.ChannelStore)
+	}
+
+	for _, file := range listenerFiles {
+		file.Close()
+	}
+	server.abortRestart(&state, files, sockets, dropped)
+
+	return err
+}
+
+// abortRestart takes back the clients and channels that were to be handed
+// over to a new process, as if they had been handed over to this one.
+// Clients that were dropped are left to be removed as usual.
+func (server *Server) abortRestart(state *restartState, files []*os.File,
+	sockets []net.Conn, dropped map[*protocol.IrcConnection]bool) {
+	members := map[string]*protocol.IrcConnection{}
+	for conn, user := range server.users {
+		if dropped[conn] {
+			members[user.nick] = conn
+			continue
+		}
+
+		delete(server.users, conn)
+		delete(server.nicks, casefold(user.nick))
+		server.clearMonitors(user)
+	}
+	server.channels = make(map[string]*channel.Channel)
+
+	resumed := make([]net.Conn, len(files))
+	for i, file := range files {
+		conn, err := net.FileConn(file)
+		if err != nil {
+			log.Printf("Taking back connection of %s failed: %v",
+				state.Connections[i].Nick, err)
+		}
+
+		resumed[i] = conn
+		file.Close()
+		sockets[i].Close()
+	}
+
+	server.resume(state, resumed, members)
+
+	for _, active := range server.listeners {
+		if l, ok := active.raw.(interface{ SetDeadline(time.Time) error }); ok {
+			l.SetDeadline(time.Time{})
+		}
+	}
+	server.restarting.Store(false)
+}
+
+// drainIncoming handles the actions of clients until the given readers
+// have all stopped and nothing is left queued.
+func (server *Server) drainIncoming(readers []<-chan bool) {
+	for _, done := range readers {
+		for stopped := false; !stopped; {
+			select {
+			case action := <-server.incoming:
+				server.handleMessage(action)
+			case <-done:
+				stopped = true
+			}
+		}
+	}
+
+	for {
+		select {
+		case action := <-server.incoming:
+			server.handleMessage(action)
+		default:
+			return
+		}
+	}
+}
+
+func writeRestartState(state restartState) (string, error) {
+	file, err := os.CreateTemp("", "channeld-restart-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(state); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// loadRestartState reads the state left by the previous process, if this
+// one was started by a restart.
+func loadRestartState() *restartState {
+	path := os.Getenv(restartStateEnv)
+	if path == "" {
+		return nil
+	}
+
+	os.Unsetenv(restartStateEnv)
+	defer os.Remove(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Reading restart state failed: %v", err)
+		return nil
+	}
+
+	state := new(restartState)
+	if err := json.Unmarshal(data, state); err != nil {
+		log.Printf("Parsing restart state failed: %v", err)
+		return nil
+	}
+
+	return state
+}
+
+func inheritListener(l config.Listener, state *restartState) (net.Listener,
+	error) {
+	if state == nil {
+		return nil, nil
+	}
+
+	for _, ls := range state.Listeners {
+		if ls.Network != l.Network || ls.Address != l.Address {
+			continue
+		}
+
+		file := os.NewFile(ls.Fd, l.Address)
+		defer file.Close()
+
+		log.Printf("Inherited listener %s %s", l.Network, l.Address)
+		return net.FileListener(file)
+	}
+
+	return nil, nil
+}
+
+// restore takes over the clients, channels and history of the previous
+// process.
+func (server *Server) restore(state *restartState) {
+	if memory, ok := server.history.(*history.MemoryStore); ok &&
+		state.History != nil {
+		memory.Import(state.History)
+	}
+
+	sockets := make([]net.Conn, len(state.Connections))
+	for i, cs := range state.Connections {
+		file := os.NewFile(cs.Fd, cs.Nick)
+		conn, err := net.FileConn(file)
+		file.Close()
+		if err != nil {
+			log.Printf("Resuming connection of %s failed: %v", cs.Nick, err)
+		}
+
+		sockets[i] = conn
+	}
+
+	server.resume(state, sockets, map[string]*protocol.IrcConnection{})
+}
+
+// resume serves the users of a restart state on the given sockets, skipping
+// those without one, and restarts its channels with them. Members listed
+// in conns are kept in the channels too.
+func (server *Server) resume(state *restartState, sockets []net.Conn,
+	conns map[string]*protocol.IrcConnection) {
+	for i, cs := range state.Connections {
+		netConn := sockets[i]
+		if netConn == nil {
+			continue
+		}
+
+		if cs.Remote != "" {
+			addr, err := net.ResolveTCPAddr("tcp", cs.Remote)
+			if err == nil {
+				netConn = &remoteConn{netConn, addr}
+			}
+		}
+
+		ip := remoteIP(netConn)
+		class := config.Config.GetClass(cs.Class)
+		if class == nil {
+			class = config.Config.ClassFor(ip)
+		}
+		server.limiter.admit(ip, class)
+
+		conn := protocol.ResumeIrcConnection(netConn, class, server.incoming,
+			cs.Input, cs.Output)
+		server.track(conn, ip, class)
+
+		user := NewUser(cs.Nick, cs.Username, cs.Realname, cs.Hostname, conn)
+		user.id = cs.ID
+		user.account = cs.Account
+		user.oper = cs.Oper
+		user.away = cs.Away
+		conn.SetFloodExempt(user.oper)
+		conn.CheckFloodExemptHost(user.hostname)
+		conn.SetCapabilities(cs.Caps)
+		server.addUser(conn, user)
+		conns[user.nick] = conn
+
+		go conn.Resume()
+	}
+
+	// monitors are set up once everyone is back, so that nobody is told
+	// about users coming online
+	for _, cs := range state.Connections {
+		if user := server.getUserByName(cs.Nick); user != nil {
+			for _, nick := range cs.Monitoring {
+				server.monitor(user, nick)
+			}
+		}
+	}
+
+	for _, cs := range state.Channels {
+		c := channel.RestoreChannel(cs, server.store, server.history,
+			server.emptyChannels, conns)
+		server.channels[c.Name] = c
+
+		for _, member := range cs.Members {
+			if user := server.getUserByName(member.Nick); user != nil {
+				user.channels[c.Name] = true
+			}
+		}
+		c.Start()
+	}
+
+	log.Printf("Resumed %d connections and %d channels",
+		len(state.Connections), len(state.Channels))
+}
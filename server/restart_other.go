@@ -0,0 +1,18 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+	"os"
+)
+
+var errRestartUnsupported = errors.New("restart is not supported here")
+
+func inheritableFile(socket interface{}) (*os.File, error) {
+	return nil, errRestartUnsupported
+}
+
+func execSelf(executable string, env []string) error {
+	return errRestartUnsupported
+}
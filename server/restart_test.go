@@ -0,0 +1,43 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/channel"
+	"github.com/jukeks/channeld/history"
+	"github.com/jukeks/channeld/protocol"
+	"github.com/stretchr/testify/assert"
+
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRestartState(t *testing.T) {
+	state := restartState{
+		ServerID:  "irc.example",
+		Listeners: []listenerState{{"tcp", ":6667", 3}},
+		Connections: []connectionState{{
+			Fd: 4, Remote: "192.0.2.1:40000", Class: "default",
+			Input: []byte("PRIVMSG #x :h"), Output: []string{"PING :x"},
+			ID: "abc1", Nick: "alice", Username: "~alice",
+			Realname: "Alice", Hostname: "example.org", Oper: true,
+			Away: "lunch", Caps: protocol.CapBatch | protocol.CapServerTime,
+			Monitoring: []string{"bob"},
+		}},
+		Channels: []channel.ChannelState{{"#x", "P", []channel.MemberState{
+			{"alice", "alice!~alice@example.org"}}}},
+		History: map[string][]history.Entry{"#x": {{"id1",
+			time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC),
+			"alice!~alice@example.org", "PRIVMSG", "PRIVMSG #x :hi"}}},
+	}
+
+	path, err := writeRestartState(state)
+	assert.Nil(t, err, "Writing state failed")
+
+	os.Setenv(restartStateEnv, path)
+	loaded := loadRestartState()
+
+	assert.Equal(t, *loaded, state, "State changed on the way")
+	assert.Equal(t, os.Getenv(restartStateEnv), "", "Variable left set")
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "State file left behind")
+}
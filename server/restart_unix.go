@@ -0,0 +1,40 @@
+//go:build unix
+
+package server
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+type fileSource interface {
+	File() (*os.File, error)
+}
+
+// inheritableFile duplicates the socket into a descriptor that survives
+// exec.
+func inheritableFile(socket interface{}) (*os.File, error) {
+	source, ok := socket.(fileSource)
+	if !ok {
+		return nil, errors.New("socket cannot be inherited")
+	}
+
+	file, err := source.File()
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, file.Fd(),
+		syscall.F_SETFD, 0)
+	if errno != 0 {
+		file.Close()
+		return nil, errno
+	}
+
+	return file, nil
+}
+
+func execSelf(executable string, env []string) error {
+	return syscall.Exec(executable, os.Args, env)
+}
@@ -0,0 +1,81 @@
+//go:build unix
+
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/protocol"
+	"github.com/stretchr/testify/assert"
+
+	"bufio"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTCPTestClient is a registered user served over a loopback socket,
+// which unlike a pipe can be handed over.
+func newTCPTestClient(server *Server, listener net.Listener,
+	nick string) *testClient {
+	client, _ := net.Dial("tcp", listener.Addr().String())
+	socket, _ := listener.Accept()
+
+	conn := protocol.NewIrcConnection(socket,
+		config.Config.GetClass(config.DefaultClass), server.incoming)
+	go conn.Resume()
+
+	user := NewUser(nick, "~"+nick, nick, "example.org", conn)
+	server.addConnection(conn)
+	server.addUser(conn, user)
+
+	return &testClient{user, client, bufio.NewReader(client)}
+}
+
+func TestFailedRestart(t *testing.T) {
+	// the state cannot be written, so the restart fails after everyone has
+	// been detached
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+
+	server := newTestServer()
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	server.listeners = []*activeListener{{config.Listener{Network: "tcp",
+		Address: listener.Addr().String()}, listener, listener}}
+
+	alice := newTCPTestClient(server, listener, "alice")
+	defer alice.close()
+	bob := newTCPTestClient(server, listener, "bob")
+	defer bob.close()
+	bob.user.oper = true
+
+	alice.send(server, "JOIN #x")
+	bob.send(server, "JOIN #x")
+	alice.read()
+	bob.read()
+
+	// a line read but not handled yet is handled before the restart
+	alice.socket.Write([]byte("PRIVMSG #x :before\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	bob.send(server, "RESTART")
+	lines := bob.read()
+	assert.Equal(t, len(lines), 2, "Restart failure not reported")
+	assert.Equal(t, lines[0], ":alice!~alice@example.org PRIVMSG #x :before",
+		"Queued line lost")
+	assert.False(t, server.restarting.Load(), "Still restarting")
+
+	// both are served on as before
+	bob.socket.Write([]byte("PRIVMSG #x :after\r\n"))
+	server.handleMessage(<-server.incoming)
+	assert.Equal(t, alice.read(), []string{
+		":bob!~bob@example.org PRIVMSG #x :after"}, "Channel not taken back")
+
+	alice.socket.Write([]byte("PING :x\r\n"))
+	server.handleMessage(<-server.incoming)
+	assert.Equal(t, alice.read(), []string{":irc.example PONG irc.example :x"},
+		"Connection not taken back")
+
+	// and new clients are accepted again
+	carol := newTCPTestClient(server, listener, "carol")
+	defer carol.close()
+}
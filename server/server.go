@@ -3,28 +3,75 @@ package server
 import (
 	"github.com/jukeks/channeld/channel"
 	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/history"
 	"github.com/jukeks/channeld/protocol"
+	"github.com/jukeks/channeld/store"
 
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Server struct {
-	channels map[string]*channel.Channel
-	users    map[*protocol.IrcConnection]*User
-	incoming chan protocol.ClientAction
-	newUsers chan protocol.ConnectionInitiationAction
-	quit     chan bool
+	channels      map[string]*channel.Channel
+	emptyChannels chan *channel.Channel
+	users         map[*protocol.IrcConnection]*User
+	nicks         map[string]*User
+	incoming      chan protocol.ClientAction
+	newUsers      chan protocol.ConnectionInitiationAction
+
+	// every accepted connection, registered or not
+	connections     map[*protocol.IrcConnection]bool
+	connectionMutex sync.Mutex
+
+	limiter   *connectionLimiter
+	listeners []*activeListener
+	store     store.ChannelStore
+	history   history.Store
+
+	// monitors holds the users monitoring each nick, by casefolded nick
+	monitors map[string]map[*User]bool
+
+	// started is when the server came up, maxUsers the most users it has
+	// had since and commands the use of each command
+	started  time.Time
+	maxUsers int
+	commands map[string]*commandStats
+
+	// motds holds the lines of the message of the day files, by path
+	motds map[string][]string
+
+	restarting       atomic.Bool
+	rehashRequests   chan bool
+	shutdownRequests chan context.Context
+	stopping         chan bool
+	stopped          chan bool
 }
 
 func NewServer(id string) *Server {
 	s := new(Server)
 	s.channels = make(map[string]*channel.Channel)
+	s.emptyChannels = make(chan *channel.Channel, 1000)
 	s.users = make(map[*protocol.IrcConnection]*User)
+	s.nicks = make(map[string]*User)
+	s.monitors = make(map[string]map[*User]bool)
+	s.started = time.Now()
+	s.commands = make(map[string]*commandStats)
 	s.incoming = make(chan protocol.ClientAction, 1000)
 	s.newUsers = make(chan protocol.ConnectionInitiationAction)
-	s.quit = make(chan bool)
+	s.connections = make(map[*protocol.IrcConnection]bool)
+	s.limiter = newConnectionLimiter()
+	s.history = history.NewMemoryStore(config.Config.HistoryLength,
+		config.Config.HistoryMaxAge)
+	s.rehashRequests = make(chan bool)
+	s.shutdownRequests = make(chan context.Context)
+	s.stopping = make(chan bool)
+	s.stopped = make(chan bool)
 
 	config.Config.ServerID = id
 
@@ -32,48 +79,146 @@ func NewServer(id string) *Server {
 }
 
 func (server *Server) Quit() {
-	server.quit <- true
-	server.quit <- true
+	ctx, cancel := context.WithTimeout(context.Background(),
+		config.Config.ShutdownTimeout)
+	defer cancel()
+
+	server.Shutdown(ctx)
 }
 
-func (server *Server) Serve() {
-	addr, _ := net.ResolveTCPAddr("tcp", ":6667")
-	listener, _ := net.ListenTCP("tcp", addr)
+// Serve opens the configured listeners and serves clients until the server
+// is shut down. Listeners that cannot be opened are reported and skipped, it is
+// an error only if none of them can.
+func (server *Server) Serve() error {
+	var err error
+	server.store, err = store.OpenChannelStore(config.Config.ChannelStore)
+	if err != nil {
+		return err
+	}
 
-	go server.serveUsers()
+	server.loadMotds()
+	state := loadRestartState()
 
-	for {
-		select {
-		case <-server.quit:
-			return
-		default:
+	for _, l := range config.Config.Listeners {
+		active, err := listen(l, state)
+		if err != nil {
+			log.Printf("Failed to listen on %s %s: %v", l.Network, l.Address,
+				err)
+			continue
 		}
 
-		listener.SetDeadline(time.Now().Add(time.Second))
-		conn, err := listener.Accept()
-		if err != nil {
-			if err, ok := err.(*net.OpError); ok && err.Timeout() {
-				continue
-			}
+		log.Printf("Listening on %s %s", l.Network, l.Address)
+		server.listeners = append(server.listeners, active)
+	}
 
-			log.Printf("Error: %v", err)
+	if len(server.listeners) == 0 {
+		return errors.New("no listener could be opened")
+	}
+
+	if state != nil {
+		server.restore(state)
+	}
+
+	if err := server.loadChannels(); err != nil {
+		return err
+	}
+
+	go server.serveUsers()
+
+	for _, active := range server.listeners {
+		if active.config.WebSocket {
+			go server.serveWebSocket(active.listener, active.config)
 			continue
 		}
 
-		ircConn := protocol.NewIrcConnection(conn, server.incoming)
-		go ircConn.Serve(server.newUsers)
+		go server.acceptLoop(active.listener, active.config)
+	}
+
+	<-server.stopping
+
+	for _, active := range server.listeners {
+		active.listener.Close()
 	}
+
+	<-server.stopped
+
+	return nil
+}
+
+func (server *Server) accept(conn net.Conn, l config.Listener) {
+	ip := remoteIP(conn)
+	class := listenerClass(l, ip)
+
+	if reason := server.limiter.admit(ip, class); reason != "" {
+		log.Printf("Rejected connection from %v: %s", ip, reason)
+		go func() {
+			protocol.WriteLine(conn,
+				fmt.Sprintf("ERROR :Closing Link: %v (%s)", ip, reason))
+			conn.Close()
+		}()
+		return
+	}
+
+	ircConn := protocol.NewIrcConnection(conn, class, server.incoming)
+	server.track(ircConn, ip, class)
+
+	go ircConn.Serve(server.newUsers)
+}
+
+// track keeps count of the connection until it is closed.
+func (server *Server) track(conn *protocol.IrcConnection, ip net.IP,
+	class *config.Class) {
+	server.addConnection(conn)
+	go func() {
+		<-conn.Closed()
+		server.limiter.release(ip, class)
+		server.removeConnection(conn)
+	}()
 }
 
 func (server *Server) serveUsers() {
 	for {
 		select {
-		case <-server.quit:
+		case <-server.stopped:
 			return
+		default:
+		}
+
+		select {
+		case ctx := <-server.shutdownRequests:
+			server.shutdown(ctx)
+		case <-server.rehashRequests:
+			server.rehash()
 		case action := <-server.incoming:
 			server.handleMessage(action)
 		case action := <-server.newUsers:
 			server.handleNewUser(action)
+		case c := <-server.emptyChannels:
+			server.handleEmptyChannel(c)
 		}
 	}
 }
+
+// loadChannels recreates the permanent channels from the channel store,
+// unless they were handed over by the previous process already.
+func (server *Server) loadChannels() error {
+	channels, err := server.store.Load()
+	if err != nil {
+		return err
+	}
+
+	for _, metadata := range channels {
+		if server.getChannel(metadata.Name) != nil {
+			continue
+		}
+
+		c := channel.NewChannelFromMetadata(metadata, server.store,
+			server.history, server.emptyChannels)
+		server.channels[c.Name] = c
+		c.Start()
+	}
+
+	log.Printf("Loaded %d channels from the channel store", len(channels))
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/protocol"
+	"github.com/jukeks/channeld/store"
+
+	"bufio"
+	"net"
+	"strings"
+	"time"
+)
+
+func newTestServer() *Server {
+	server := NewServer("irc.example")
+	server.store, _ = store.OpenChannelStore("")
+
+	return server
+}
+
+// testClient is a registered user served over a pipe, along with the
+// client's end of it.
+type testClient struct {
+	user   *User
+	socket net.Conn
+	reader *bufio.Reader
+}
+
+func newTestClient(server *Server, nick string) *testClient {
+	socket, client := net.Pipe()
+	conn := protocol.NewIrcConnection(socket,
+		config.Config.GetClass(config.DefaultClass), server.incoming)
+	go conn.Resume()
+
+	user := NewUser(nick, "~"+nick, nick, "example.org", conn)
+	server.addConnection(conn)
+	server.addUser(conn, user)
+
+	return &testClient{user, client, bufio.NewReader(client)}
+}
+
+// send has the server handle a line from the client.
+func (c *testClient) send(server *Server, line string) {
+	size := len(line) + 2
+	tags, line := protocol.SplitTags(line)
+	command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+	server.handleMessage(protocol.ClientAction{c.user.conn,
+		protocol.ParseMessage(line), protocol.NewMetadata(tags), command,
+		size})
+}
+
+// read returns the lines written to the client until it has been quiet
+// for a moment.
+func (c *testClient) read() []string {
+	lines := []string{}
+	for {
+		c.socket.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return lines
+		}
+
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+	}
+}
+
+func (c *testClient) close() {
+	c.socket.Close()
+	c.user.conn.Close()
+}
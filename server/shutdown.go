@@ -0,0 +1,88 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/protocol"
+
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+func (server *Server) addConnection(conn *protocol.IrcConnection) {
+	server.connectionMutex.Lock()
+	defer server.connectionMutex.Unlock()
+
+	server.connections[conn] = true
+}
+
+func (server *Server) removeConnection(conn *protocol.IrcConnection) {
+	server.connectionMutex.Lock()
+	defer server.connectionMutex.Unlock()
+
+	delete(server.connections, conn)
+}
+
+func (server *Server) getConnections() []*protocol.IrcConnection {
+	server.connectionMutex.Lock()
+	defer server.connectionMutex.Unlock()
+
+	conns := []*protocol.IrcConnection{}
+	for conn := range server.connections {
+		conns = append(conns, conn)
+	}
+
+	return conns
+}
+
+// Shutdown stops the server. Clients have until the context's deadline to
+// receive what is still queued for them.
+func (server *Server) Shutdown(ctx context.Context) {
+	select {
+	case server.shutdownRequests <- ctx:
+	case <-server.stopped:
+		return
+	case <-ctx.Done():
+		return
+	}
+
+	select {
+	case <-server.stopped:
+	case <-ctx.Done():
+	}
+}
+
+// shutdown runs in the server goroutine: it stops the listeners, says
+// goodbye to every client, waits for their queues to drain and stops the
+// channels.
+func (server *Server) shutdown(ctx context.Context) {
+	log.Printf("Shutting down")
+	close(server.stopping)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(config.Config.ShutdownTimeout)
+	}
+
+	var wg sync.WaitGroup
+	for _, conn := range server.getConnections() {
+		wg.Add(1)
+		go func(conn *protocol.IrcConnection) {
+			defer wg.Done()
+			conn.Quit("Server shutting down", deadline)
+		}(conn)
+	}
+	wg.Wait()
+
+	for _, c := range server.channels {
+		c.Stop()
+	}
+
+	if err := server.store.Close(); err != nil {
+		log.Printf("Closing channel store failed: %v", err)
+	}
+
+	close(server.stopped)
+	log.Printf("Shutdown complete")
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/store"
+	"github.com/stretchr/testify/assert"
+
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// closeRecordingStore is a store that keeps nothing but notices being
+// closed.
+type closeRecordingStore struct {
+	store.ChannelStore
+	closed bool
+}
+
+func (s *closeRecordingStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	server := newTestServer()
+	channels := &closeRecordingStore{server.store, false}
+	server.store = channels
+
+	a := newTestClient(server, "alice")
+	b := newTestClient(server, "bob")
+	a.send(server, "JOIN #x")
+
+	received := make(chan string, 2)
+	for _, c := range []*testClient{a, b} {
+		go func(c *testClient) {
+			data, _ := io.ReadAll(c.reader)
+			received <- string(data)
+		}(c)
+	}
+
+	go server.serveUsers()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+
+	for i := 0; i < 2; i++ {
+		assert.Contains(t, <-received, "ERROR :Server shutting down\r\n",
+			"Client not told")
+	}
+
+	<-server.stopped
+	assert.True(t, channels.closed, "Store not closed")
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	server := newTestServer()
+
+	// the client never reads what it is sent
+	c := newTestClient(server, "alice")
+	defer c.close()
+
+	go server.serveUsers()
+	ctx, cancel := context.WithTimeout(context.Background(),
+		200*time.Millisecond)
+	defer cancel()
+
+	server.Shutdown(ctx)
+
+	select {
+	case <-server.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown did not finish at the deadline")
+	}
+
+	select {
+	case <-c.user.conn.Closed():
+	default:
+		t.Fatal("Client left connected")
+	}
+}
@@ -7,10 +7,31 @@ import (
 )
 
 type User struct {
+	// id identifies the user for as long as they stay connected. Private
+	// history is kept under it, so that it does not pass on with the nick.
+	id string
+
 	nick     string
 	username string
 	realname string
 	hostname string
+	oper     bool
+
+	// account is the account the user is logged in to. There is no account
+	// system yet, so nobody logs in and everyone shows as "*" in
+	// extended-join. Logging in and out is to go through setAccount, which
+	// tells peers with account-notify.
+	account string
+
+	// away is the away message of a user who is away
+	away string
+
+	// channels the user has joined, kept for access checks
+	channels map[string]bool
+
+	// monitoring maps the casefolded nicks on the user's MONITOR list to
+	// the nicks as given
+	monitoring map[string]string
 
 	conn *protocol.IrcConnection
 }
@@ -18,10 +39,13 @@ type User struct {
 func NewUser(nick, username, realname, hostname string,
 	conn *protocol.IrcConnection) *User {
 	u := new(User)
+	u.id = protocol.NewMessageID()
 	u.nick = nick
 	u.username = username
 	u.realname = realname
 	u.hostname = hostname
+	u.channels = make(map[string]bool)
+	u.monitoring = make(map[string]string)
 	u.conn = conn
 
 	return u
@@ -31,6 +55,15 @@ func (user *User) hostmask() string {
 	return fmt.Sprintf("%s!%s@%s", user.nick, user.username, user.hostname)
 }
 
+// accountName is the account the user is logged in to, or "*".
+func (user *User) accountName() string {
+	if user.account == "" {
+		return "*"
+	}
+
+	return user.account
+}
+
 func (user *User) close() {
 	user.conn.Close()
 }
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/config"
+	"github.com/jukeks/channeld/protocol"
+
+	"fmt"
+	"log"
+	"strings"
+)
+
+// channelPeers are the users sharing a channel with the user.
+func (server *Server) channelPeers(user *User) []*User {
+	peers := []*User{}
+	for _, other := range server.users {
+		if other == user {
+			continue
+		}
+
+		for name := range user.channels {
+			if other.channels[name] {
+				peers = append(peers, other)
+				break
+			}
+		}
+	}
+
+	return peers
+}
+
+// notifyPeers tells the user's channel peers about a change to the user.
+// Only peers with the capability the message belongs to receive it.
+func (server *Server) notifyPeers(user *User, outgoing *protocol.Outgoing) {
+	for _, peer := range server.channelPeers(user) {
+		peer.conn.SendOutgoing(outgoing)
+	}
+}
+
+func (server *Server) handleSetName(user *User,
+	message protocol.SetNameMessage, metadata protocol.Metadata,
+	response *protocol.Response) {
+	if strings.TrimSpace(message.Realname) == "" {
+		response.Fail("SETNAME", "INVALID_REALNAME",
+			"Realname cannot be empty")
+		return
+	}
+
+	outgoing := protocol.NewOutgoing(user.hostmask(), message, metadata)
+	user.realname = message.Realname
+
+	server.notifyPeers(user, outgoing)
+	response.SendOutgoing(outgoing)
+}
+
+func validHostname(hostname string) bool {
+	return hostname != "" && !strings.ContainsAny(hostname, " !@:,*?")
+}
+
+// handleChgHost lets operators change the host a user is shown with.
+func (server *Server) handleChgHost(user *User,
+	message protocol.ChgHostMessage, metadata protocol.Metadata,
+	response *protocol.Response) {
+	if !server.requireOper(user, response) {
+		return
+	}
+
+	id := config.Config.ServerID
+	target := server.getUserByName(message.Nick)
+	if target == nil {
+		response.Error(user.nick, protocol.ERR_NOSUCHNICK, message.Nick)
+		return
+	}
+
+	if !validHostname(message.Hostname) {
+		response.Fail("CHGHOST", "INVALID_HOSTNAME", "Invalid hostname",
+			message.Hostname)
+		return
+	}
+
+	log.Printf("%s changed the host of %s to %s", user.nick, target.nick,
+		message.Hostname)
+
+	change := protocol.HostChangeMessage{target.username, message.Hostname}
+	outgoing := protocol.NewOutgoing(target.hostmask(), change, metadata)
+	target.hostname = message.Hostname
+
+	server.notifyPeers(target, outgoing)
+	target.conn.SendOutgoing(outgoing)
+	server.updateMember(target, change, metadata)
+
+	response.Send(fmt.Sprintf(":%s NOTICE %s :Changed the host of %s to %s",
+		id, user.nick, target.nick, target.hostname))
+}
+
+// setAccount logs the user in to an account, or out of it with "*", and
+// tells their peers with account-notify.
+func (server *Server) setAccount(user *User, account string) {
+	outgoing := protocol.NewOutgoing(user.hostmask(),
+		protocol.AccountMessage{account}, protocol.NewMetadata(nil))
+	user.account = account
+	if account == "*" {
+		user.account = ""
+	}
+
+	server.notifyPeers(user, outgoing)
+}
+
+// updateMember tells the user's channels about a change in how the user is
+// shown.
+func (server *Server) updateMember(user *User, message protocol.IrcMessage,
+	metadata protocol.Metadata) {
+	for name := range user.channels {
+		c := server.getChannel(name)
+		if c == nil {
+			continue
+		}
+
+		c.Incoming <- protocol.ChannelAction{user.hostmask(), user.nick,
+			user.conn, message, metadata,
+			user.conn.NewResponse(protocol.Metadata{})}
+	}
+}
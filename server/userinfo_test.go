@@ -0,0 +1,44 @@
+package server
+
+import (
+	"github.com/jukeks/channeld/protocol"
+	"github.com/stretchr/testify/assert"
+
+	"testing"
+)
+
+func TestAccountNotify(t *testing.T) {
+	server := newTestServer()
+	alice := newTestClient(server, "alice")
+	defer alice.close()
+	alice.user.conn.SetCapabilities(protocol.CapAccountNotify |
+		protocol.CapExtendedJoin)
+	bob := newTestClient(server, "bob")
+	defer bob.close()
+	carol := newTestClient(server, "carol")
+	defer carol.close()
+
+	alice.send(server, "JOIN #x")
+	carol.send(server, "JOIN #x")
+	bob.send(server, "JOIN #x")
+	alice.read()
+	carol.read()
+	bob.read()
+
+	server.setAccount(bob.user, "bob")
+	assert.Equal(t, alice.read(), []string{":bob!~bob@example.org ACCOUNT bob"},
+		"Login not notified")
+	assert.Equal(t, len(carol.read()), 0, "Login sent without account-notify")
+
+	bob.send(server, "PART #x")
+	bob.send(server, "JOIN #x")
+	assert.Equal(t, alice.read(), []string{
+		":bob!~bob@example.org PART :#x",
+		":bob!~bob@example.org JOIN #x bob :bob",
+	}, "Account not shown in extended-join")
+
+	server.setAccount(bob.user, "*")
+	assert.Equal(t, alice.read(), []string{":bob!~bob@example.org ACCOUNT *"},
+		"Logout not notified")
+	assert.Equal(t, bob.user.accountName(), "*", "Account kept on logout")
+}
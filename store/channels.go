@@ -0,0 +1,279 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ChannelMetadata is what is remembered of a channel across restarts.
+type ChannelMetadata struct {
+	Name string
+	Mode string
+}
+
+// ChannelStore persists the metadata of channels that are to outlive the
+// server process. Implementations are safe for concurrent use.
+type ChannelStore interface {
+	Load() ([]ChannelMetadata, error)
+	Save(channel ChannelMetadata) error
+	Delete(name string) error
+	Close() error
+}
+
+// OpenChannelStore opens the file store at path, or a store that keeps
+// nothing if path is empty.
+func OpenChannelStore(path string) (ChannelStore, error) {
+	if path == "" {
+		return nullChannelStore{}, nil
+	}
+
+	return OpenFileChannelStore(path)
+}
+
+type nullChannelStore struct{}
+
+func (s nullChannelStore) Load() ([]ChannelMetadata, error) {
+	return []ChannelMetadata{}, nil
+}
+
+func (s nullChannelStore) Save(channel ChannelMetadata) error {
+	return nil
+}
+
+func (s nullChannelStore) Delete(name string) error {
+	return nil
+}
+
+func (s nullChannelStore) Close() error {
+	return nil
+}
+
+// compactAfter is the number of journal entries that triggers writing a
+// new snapshot.
+const compactAfter = 1000
+
+type journalEntry struct {
+	Delete  bool `json:",omitempty"`
+	Channel ChannelMetadata
+}
+
+// FileChannelStore keeps a JSON snapshot of all channels at path and
+// journals every change to path.journal before acknowledging it. The
+// journal is folded into the snapshot when the store is opened and once it
+// grows long.
+type FileChannelStore struct {
+	path string
+
+	mutex    sync.Mutex
+	channels map[string]ChannelMetadata
+	journal  *os.File
+	entries  int
+}
+
+func OpenFileChannelStore(path string) (*FileChannelStore, error) {
+	s := new(FileChannelStore)
+	s.path = path
+	s.channels = make(map[string]ChannelMetadata)
+
+	if err := s.readSnapshot(); err != nil {
+		return nil, err
+	}
+
+	if err := s.replayJournal(); err != nil {
+		return nil, err
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileChannelStore) journalPath() string {
+	return s.path + ".journal"
+}
+
+func (s *FileChannelStore) readSnapshot() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	channels := []ChannelMetadata{}
+	if err := json.Unmarshal(data, &channels); err != nil {
+		return fmt.Errorf("corrupt channel snapshot %s: %v", s.path, err)
+	}
+
+	for _, c := range channels {
+		s.channels[c.Name] = c
+	}
+
+	return nil
+}
+
+// replayJournal applies the journal on top of the snapshot. A torn last
+// line from a crash mid-write is ignored.
+func (s *FileChannelStore) replayJournal() error {
+	file, err := os.Open(s.journalPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := journalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+
+		s.apply(entry)
+	}
+
+	return scanner.Err()
+}
+
+func (s *FileChannelStore) apply(entry journalEntry) {
+	if entry.Delete {
+		delete(s.channels, entry.Channel.Name)
+		return
+	}
+
+	s.channels[entry.Channel.Name] = entry.Channel
+}
+
+// compact writes the current state as the new snapshot and starts an empty
+// journal. The snapshot is replaced atomically by renaming.
+func (s *FileChannelStore) compact() error {
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+
+	data, err := json.Marshal(s.list())
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	journal, err := os.OpenFile(s.journalPath(),
+		os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	s.journal = journal
+	s.entries = 0
+
+	return nil
+}
+
+func writeSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (s *FileChannelStore) list() []ChannelMetadata {
+	channels := []ChannelMetadata{}
+	for _, c := range s.channels {
+		channels = append(channels, c)
+	}
+
+	return channels
+}
+
+func (s *FileChannelStore) write(entry journalEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.journal == nil {
+		return os.ErrClosed
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.journal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	if err := s.journal.Sync(); err != nil {
+		return err
+	}
+
+	s.apply(entry)
+	s.entries += 1
+	if s.entries >= compactAfter {
+		return s.compact()
+	}
+
+	return nil
+}
+
+func (s *FileChannelStore) Load() ([]ChannelMetadata, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.list(), nil
+}
+
+func (s *FileChannelStore) Save(channel ChannelMetadata) error {
+	return s.write(journalEntry{false, channel})
+}
+
+func (s *FileChannelStore) Delete(name string) error {
+	return s.write(journalEntry{true, ChannelMetadata{Name: name}})
+}
+
+func (s *FileChannelStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+
+	err := s.compact()
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+
+	return err
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileChannelStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "channels.json")
+
+	s, err := OpenFileChannelStore(path)
+	assert.NoError(t, err, "Opening an empty store failed")
+	assert.NoError(t, s.Save(ChannelMetadata{"#a", "P"}), "Save failed")
+	assert.NoError(t, s.Save(ChannelMetadata{"#b", "P"}), "Save failed")
+	assert.NoError(t, s.Delete("#b"), "Delete failed")
+
+	// reopening without Close replays the journal like after a crash
+	s, err = OpenFileChannelStore(path)
+	assert.NoError(t, err, "Reopening failed")
+	channels, _ := s.Load()
+	assert.Equal(t, channels, []ChannelMetadata{{"#a", "P"}},
+		"Journal not replayed")
+
+	// a torn last journal line is ignored
+	s.Save(ChannelMetadata{"#c", "P"})
+	journal, _ := os.OpenFile(path+".journal", os.O_APPEND|os.O_WRONLY, 0600)
+	journal.Write([]byte(`{"Channel":{"Na`))
+	journal.Close()
+
+	s, err = OpenFileChannelStore(path)
+	assert.NoError(t, err, "Torn journal not tolerated")
+	channels, _ = s.Load()
+	assert.Len(t, channels, 2, "Channels lost")
+	assert.NoError(t, s.Close(), "Close failed")
+}
@@ -0,0 +1,263 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+var (
+	errMessageTooLong = errors.New("websocket message too long")
+	errLineBreak      = errors.New("websocket message with a line break")
+)
+
+// Conn carries IRC over a WebSocket, one line per message. It is a
+// net.Conn that reads and writes CRLF terminated lines like a plain
+// socket, so it can be handed to protocol.NewIrcConnection as is.
+type Conn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	remote     net.Addr
+	binary     bool
+	maxMessage int
+
+	// pending is the unread part of the last message, including the CRLF
+	// appended to it
+	pending []byte
+
+	writeMutex sync.Mutex
+	partial    []byte
+	closed     bool
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		message, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+
+		// every message is a single line, line breaks within one are not
+		// allowed
+		line := strings.TrimRight(string(message), "\r\n")
+		if strings.ContainsAny(line, "\r\n") {
+			c.writeFrame(opClose, closePayload(1003, "one line per message"))
+			return 0, errLineBreak
+		}
+
+		c.pending = []byte(line + "\r\n")
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+// readMessage reads frames until a complete data message has been
+// assembled, answering pings and close frames on the way.
+func (c *Conn) readMessage() ([]byte, error) {
+	message := []byte{}
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			c.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return nil, io.EOF
+		}
+
+		message = append(message, payload...)
+		if len(message) > c.maxMessage {
+			c.writeFrame(opClose, closePayload(1009, "message too long"))
+			return nil, errMessageTooLong
+		}
+
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+
+	if !masked {
+		return false, 0, nil, errors.New("unmasked frame from client")
+	}
+
+	if length > uint64(c.maxMessage) {
+		c.writeFrame(opClose, closePayload(1009, "message too long"))
+		return false, 0, nil, errMessageTooLong
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.reader, mask); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// Write sends every complete line as a message of its own, an unterminated
+// tail is kept until the rest of it is written. The lock is held until the
+// frames are out so that those of concurrent writes are not interleaved.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.partial = append(c.partial, p...)
+	for {
+		i := strings.Index(string(c.partial), "\n")
+		if i < 0 {
+			break
+		}
+
+		line := strings.TrimRight(string(c.partial[:i]), "\r")
+		c.partial = c.partial[i+1:]
+
+		if err := c.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// writeLine is called with the write lock held.
+func (c *Conn) writeLine(line string) error {
+	if c.binary {
+		return c.sendFrame(opBinary, []byte(line))
+	}
+
+	if !utf8.ValidString(line) {
+		line = strings.ToValidUTF8(line, "�")
+	}
+
+	return c.sendFrame(opText, []byte(line))
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	return c.sendFrame(opcode, payload)
+}
+
+// sendFrame is called with the write lock held.
+func (c *Conn) sendFrame(opcode byte, payload []byte) error {
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	frame := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	frame = append(frame, payload...)
+
+	_, err := c.conn.Write(frame)
+	if opcode == opClose {
+		c.closed = true
+	}
+
+	return err
+}
+
+func closePayload(code uint16, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+
+	return append(payload, reason...)
+}
+
+func (c *Conn) Close() error {
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(opClose, closePayload(1000, ""))
+
+	return c.conn.Close()
+}
+
+// RemoteAddr is the client's address, which is taken from the proxy
+// headers when the request came through a trusted proxy.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"github.com/stretchr/testify/assert"
+
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func maskedFrame(opcode byte, payload string) []byte {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+
+	return frame
+}
+
+func TestConnLines(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			conn, err := Upgrade(w, r, Options{})
+			if err == nil {
+				conns <- conn
+			}
+		}))
+	defer server.Close()
+
+	client, err := net.Dial("tcp", server.Listener.Addr().String())
+	assert.NoError(t, err, "Dial failed")
+	defer client.Close()
+
+	fmt.Fprintf(client, "GET / HTTP/1.1\r\nHost: irc\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Protocol: text.ircv3.net\r\n\r\n")
+
+	reader := bufio.NewReader(client)
+	response, err := http.ReadResponse(reader, nil)
+	assert.NoError(t, err, "Handshake response unreadable")
+	assert.Equal(t, response.StatusCode, 101, "Upgrade refused")
+	assert.Equal(t, response.Header.Get("Sec-WebSocket-Accept"),
+		"s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", "Accept key computed incorrectly")
+	assert.Equal(t, response.Header.Get("Sec-WebSocket-Protocol"),
+		TextProtocol, "Subprotocol not selected")
+
+	conn := <-conns
+	client.Write(maskedFrame(opText, "NICK juke"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err, "Reading line failed")
+	assert.Equal(t, line, "NICK juke\r\n", "Message not turned into a line")
+
+	fmt.Fprintf(conn, "PING :a\r\nPING :b\r\n")
+	for _, expected := range []string{"PING :a", "PING :b"} {
+		header := make([]byte, 2)
+		reader.Read(header)
+		assert.Equal(t, header[0], byte(0x80|opText), "Expected text frame")
+		payload := make([]byte, header[1])
+		reader.Read(payload)
+		assert.Equal(t, string(payload), expected, "Line not sent as frame")
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	opts := Options{TrustedProxies: []string{"10.0.0.0/8"}}
+	proxy := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	stranger := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-For", "198.51.100.7, 203.0.113.5, 10.0.0.2")
+
+	addr := opts.clientAddr(r, proxy).(*net.TCPAddr)
+	assert.Equal(t, addr.IP.String(), "203.0.113.5",
+		"Client address not taken from the last untrusted hop")
+	assert.Equal(t, opts.clientAddr(r, stranger), net.Addr(stranger),
+		"Headers from an untrusted peer believed")
+}
+
+func TestConnLineBreak(t *testing.T) {
+	socket, client := net.Pipe()
+	defer client.Close()
+
+	conn := &Conn{conn: socket, reader: bufio.NewReader(socket),
+		maxMessage: 512}
+	go client.Write(maskedFrame(opText, "NICK juke\r\nQUIT"))
+
+	closed := make(chan []byte, 1)
+	go func() {
+		frame := make([]byte, 64)
+		n, _ := client.Read(frame)
+		closed <- frame[:n]
+	}()
+
+	_, err := conn.Read(make([]byte, 64))
+	assert.Equal(t, err, errLineBreak, "Message with a line break accepted")
+
+	frame := <-closed
+	assert.Equal(t, frame[0], byte(0x80|opClose), "Connection not closed")
+	assert.Equal(t, string(frame[4:]), "one line per message",
+		"Reason not given")
+}
+
+func TestConnConcurrentWrites(t *testing.T) {
+	socket, client := net.Pipe()
+	defer client.Close()
+
+	conn := &Conn{conn: socket}
+	for i := 0; i < 2; i++ {
+		go fmt.Fprintf(conn, "PRIVMSG #x :%d\r\nPRIVMSG #x :%d\r\n", i, i)
+	}
+
+	reader := bufio.NewReader(client)
+	payloads := []string{}
+	for i := 0; i < 4; i++ {
+		header := make([]byte, 2)
+		reader.Read(header)
+		payload := make([]byte, header[1])
+		reader.Read(payload)
+		payloads = append(payloads, string(payload))
+	}
+
+	assert.Equal(t, payloads[0], payloads[1], "Frames of writes interleaved")
+	assert.Equal(t, payloads[2], payloads[3], "Frames of writes interleaved")
+}
@@ -0,0 +1,199 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	TextProtocol   = "text.ircv3.net"
+	BinaryProtocol = "binary.ircv3.net"
+)
+
+type Options struct {
+	// Origins lists the allowed Origin headers, empty allows any origin.
+	Origins []string
+
+	// TrustedProxies are the CIDR blocks whose X-Forwarded-For and
+	// X-Real-IP headers are believed.
+	TrustedProxies []string
+
+	// MaxMessage is the longest message accepted from the client.
+	MaxMessage int
+}
+
+func headerContains(header http.Header, name, value string) bool {
+	for _, v := range header.Values(name) {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (opts Options) originAllowed(origin string) bool {
+	if len(opts.Origins) == 0 {
+		return true
+	}
+
+	for _, allowed := range opts.Origins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (opts Options) trusted(ip net.IP) bool {
+	for _, cidr := range opts.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientAddr returns the peer's address, or the address of the client
+// behind it if the peer is a trusted proxy. X-Forwarded-For is walked from
+// the right so that a client cannot forge its own entry.
+func (opts Options) clientAddr(r *http.Request, peer net.Addr) net.Addr {
+	tcpAddr, ok := peer.(*net.TCPAddr)
+	if !ok || !opts.trusted(tcpAddr.IP) {
+		return peer
+	}
+
+	forwarded := []string{}
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+
+		if i == 0 || !opts.trusted(ip) {
+			return &net.TCPAddr{IP: ip}
+		}
+	}
+
+	if ip := net.ParseIP(r.Header.Get("X-Real-IP")); ip != nil {
+		return &net.TCPAddr{IP: ip}
+	}
+
+	return peer
+}
+
+// selectProtocol picks the first IRC subprotocol offered by the client.
+// Clients that offer none get the text protocol without it being named.
+func selectProtocol(r *http.Request) (string, bool) {
+	offered := r.Header.Values("Sec-WebSocket-Protocol")
+	if len(offered) == 0 {
+		return "", false
+	}
+
+	for _, v := range offered {
+		for _, protocol := range strings.Split(v, ",") {
+			switch protocol = strings.TrimSpace(protocol); protocol {
+			case TextProtocol, BinaryProtocol:
+				return protocol, true
+			}
+		}
+	}
+
+	return "", true
+}
+
+// Upgrade completes the WebSocket handshake of an HTTP request and takes
+// over its connection. On failure the HTTP error has been sent already.
+func Upgrade(w http.ResponseWriter, r *http.Request, opts Options) (*Conn,
+	error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket upgrade expected", http.StatusBadRequest)
+		return nil, errors.New("not a websocket upgrade")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return nil, errors.New("missing websocket key")
+	}
+
+	origin := r.Header.Get("Origin")
+	if !opts.originAllowed(origin) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("origin %s not allowed", origin)
+	}
+
+	protocol, offered := selectProtocol(r)
+	if offered && protocol == "" {
+		http.Error(w, "No supported subprotocol", http.StatusBadRequest)
+		return nil, errors.New("no supported subprotocol")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Upgrade not supported", http.StatusInternalServerError)
+		return nil, errors.New("connection cannot be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// the HTTP server's timeouts must not apply to the IRC session
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " +
+		base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if protocol != "" {
+		response += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+
+	if _, err := conn.Write([]byte(response + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	maxMessage := opts.MaxMessage
+	if maxMessage <= 0 {
+		maxMessage = 8192
+	}
+
+	c := new(Conn)
+	c.conn = conn
+	c.reader = rw.Reader
+	c.remote = opts.clientAddr(r, conn.RemoteAddr())
+	c.binary = protocol == BinaryProtocol
+	c.maxMessage = maxMessage
+
+	return c, nil
+}